@@ -0,0 +1,291 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/model"
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/validator"
+)
+
+func (app *application) showCartHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	cart, err := app.models.Carts.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"cart": cart}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) addCartItemHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ProductID int `json:"product_id"`
+		Quantity  int `json:"quantity"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	item := &model.CartItem{
+		ProductID: input.ProductID,
+		Quantity:  input.Quantity,
+	}
+
+	v := validator.New()
+
+	if model.ValidateCartItem(v, item); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Make sure that the product exists before it is put into the cart.
+	_, err = app.models.Products.Get(item.ProductID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			v.AddError("product_id", "product does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	cart, err := app.models.Carts.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Carts.AddItem(cart.ID, item)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Read the cart again so that the response contains the recalculated subtotals.
+	cart, err = app.models.Carts.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"cart": cart}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateCartItemHandler(w http.ResponseWriter, r *http.Request) {
+	productID, err := app.readIDParam(r, "product_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Quantity int `json:"quantity"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	item := &model.CartItem{
+		ProductID: productID,
+		Quantity:  input.Quantity,
+	}
+
+	v := validator.New()
+
+	if model.ValidateCartItem(v, item); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	cart, err := app.models.Carts.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Carts.SetQuantity(cart.ID, item)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	cart, err = app.models.Carts.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"cart": cart}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) removeCartItemHandler(w http.ResponseWriter, r *http.Request) {
+	productID, err := app.readIDParam(r, "product_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	cart, err := app.models.Carts.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Carts.RemoveItem(cart.ID, productID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	cart, err = app.models.Carts.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"cart": cart}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) clearCartHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	cart, err := app.models.Carts.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Carts.Clear(cart.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "cart successfully cleared"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// mergeCartHandler is called by the storefront right after an anonymous visitor logs in. The
+// items collected during the anonymous session are added to the user's persistent cart.
+func (app *application) mergeCartHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Items []struct {
+			ProductID int `json:"product_id"`
+			Quantity  int `json:"quantity"`
+		} `json:"items"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input.Items) <= 100, "items", "must not contain more than 100 items")
+
+	items := make([]*model.CartItem, 0, len(input.Items))
+
+	for i, in := range input.Items {
+		item := &model.CartItem{
+			ProductID: in.ProductID,
+			Quantity:  in.Quantity,
+		}
+
+		// Validate every item with its own validator so that the error keys can be prefixed
+		// with the position of the item in the request.
+		iv := validator.New()
+		if model.ValidateCartItem(iv, item); !iv.Valid() {
+			for key, message := range iv.Errors {
+				v.AddError(fmt.Sprintf("items[%d].%s", i, key), message)
+			}
+			continue
+		}
+
+		_, err := app.models.Products.Get(item.ProductID)
+		if err != nil {
+			switch {
+			case errors.Is(err, model.ErrRecordNotFound):
+				v.AddError(fmt.Sprintf("items[%d].product_id", i), "product does not exist")
+				continue
+			default:
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		items = append(items, item)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	cart, err := app.models.Carts.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Carts.Merge(cart.ID, items)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	cart, err = app.models.Carts.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"cart": cart}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/model"
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/validator"
+	"github.com/gorilla/mux"
+)
+
+func (app *application) createCouponHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code           string     `json:"code"`
+		Kind           string     `json:"kind"`
+		Value          int64      `json:"value"`
+		Category       string     `json:"category"`
+		SellerID       int        `json:"seller_id"`
+		MinOrder       int64      `json:"min_order"`
+		MaxUses        int        `json:"max_uses"`
+		MaxUsesPerUser int        `json:"max_uses_per_user"`
+		StartsAt       *time.Time `json:"starts_at"`
+		EndsAt         *time.Time `json:"ends_at"`
+		Active         *bool      `json:"active"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	coupon := &model.Coupon{
+		Code:           input.Code,
+		Kind:           input.Kind,
+		Value:          input.Value,
+		Category:       input.Category,
+		SellerID:       input.SellerID,
+		MinOrder:       input.MinOrder,
+		MaxUses:        input.MaxUses,
+		MaxUsesPerUser: input.MaxUsesPerUser,
+		StartsAt:       input.StartsAt,
+		EndsAt:         input.EndsAt,
+		Active:         true,
+	}
+
+	if input.Active != nil {
+		coupon.Active = *input.Active
+	}
+
+	v := validator.New()
+
+	if model.ValidateCoupon(v, coupon); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Coupons.Insert(coupon)
+	if err != nil {
+		app.couponWriteErrorResponse(w, r, v, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"coupon": coupon}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listCouponsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string
+		model.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Code = app.readString(qs, "code", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+
+	input.Filters.SortSafeList = []string{"id", "code", "created_at", "-id", "-code", "-created_at"}
+
+	if model.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	coupons, metadata, err := app.models.Coupons.GetAll(input.Code, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"coupons": coupons, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showCouponHandler(w http.ResponseWriter, r *http.Request) {
+	coupon, ok := app.readCoupon(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"coupon": coupon}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateCouponHandler(w http.ResponseWriter, r *http.Request) {
+	coupon, ok := app.readCoupon(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Code           *string    `json:"code"`
+		Kind           *string    `json:"kind"`
+		Value          *int64     `json:"value"`
+		Category       *string    `json:"category"`
+		SellerID       *int       `json:"seller_id"`
+		MinOrder       *int64     `json:"min_order"`
+		MaxUses        *int       `json:"max_uses"`
+		MaxUsesPerUser *int       `json:"max_uses_per_user"`
+		StartsAt       *time.Time `json:"starts_at"`
+		EndsAt         *time.Time `json:"ends_at"`
+		Active         *bool      `json:"active"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Code != nil {
+		coupon.Code = *input.Code
+	}
+	if input.Kind != nil {
+		coupon.Kind = *input.Kind
+	}
+	if input.Value != nil {
+		coupon.Value = *input.Value
+	}
+	if input.Category != nil {
+		coupon.Category = *input.Category
+	}
+	if input.SellerID != nil {
+		coupon.SellerID = *input.SellerID
+	}
+	if input.MinOrder != nil {
+		coupon.MinOrder = *input.MinOrder
+	}
+	if input.MaxUses != nil {
+		coupon.MaxUses = *input.MaxUses
+	}
+	if input.MaxUsesPerUser != nil {
+		coupon.MaxUsesPerUser = *input.MaxUsesPerUser
+	}
+	if input.StartsAt != nil {
+		coupon.StartsAt = input.StartsAt
+	}
+	if input.EndsAt != nil {
+		coupon.EndsAt = input.EndsAt
+	}
+	if input.Active != nil {
+		coupon.Active = *input.Active
+	}
+
+	v := validator.New()
+
+	if model.ValidateCoupon(v, coupon); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Coupons.Update(coupon)
+	if err != nil {
+		app.couponWriteErrorResponse(w, r, v, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"coupon": coupon}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteCouponHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r, "coupon_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Coupons.Delete(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "coupon successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// applyCouponHandler prices a list of products with a coupon and returns the breakdown of the
+// discount, without redeeming the coupon. The coupon is redeemed when an order is placed with it.
+func (app *application) applyCouponHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Items []struct {
+			ProductID int `json:"product_id"`
+			Quantity  int `json:"quantity"`
+		} `json:"items"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.Items) > 0, "items", "must contain at least one item")
+	v.Check(len(input.Items) <= 100, "items", "must not contain more than 100 items")
+
+	items := make([]*model.CouponItem, len(input.Items))
+	seen := make(map[int]bool, len(input.Items))
+
+	for i, item := range input.Items {
+		if item.Quantity == 0 {
+			item.Quantity = 1
+		}
+
+		v.Check(item.ProductID > 0, fmt.Sprintf("items[%d].product_id", i), "must be provided")
+		v.Check(item.Quantity > 0, fmt.Sprintf("items[%d].quantity", i), "must be greater than zero")
+		v.Check(item.Quantity <= 1000, fmt.Sprintf("items[%d].quantity", i), "must not be more than 1000")
+		v.Check(!seen[item.ProductID], fmt.Sprintf("items[%d].product_id", i), "must not be duplicated")
+		seen[item.ProductID] = true
+
+		items[i] = &model.CouponItem{ProductID: item.ProductID, Quantity: item.Quantity}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	quote, err := app.models.Coupons.Quote(mux.Vars(r)["code"], user.ID, items)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			v.AddError("items", "one or more products do not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, model.ErrCouponNotApplicable):
+			v.AddError("code", err.Error())
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"quote": quote}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readCoupon reads the coupon of the coupon_id route parameter, sending a 404 Not Found response
+// if it doesn't exist.
+func (app *application) readCoupon(w http.ResponseWriter, r *http.Request) (*model.Coupon, bool) {
+	id, err := app.readIDParam(r, "coupon_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	coupon, err := app.models.Coupons.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return coupon, true
+}
+
+func (app *application) couponWriteErrorResponse(w http.ResponseWriter, r *http.Request, v *validator.Validator, err error) {
+	switch {
+	case errors.Is(err, model.ErrDuplicateCoupon):
+		v.AddError("code", "a coupon with this code already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, model.ErrRecordNotFound):
+		v.AddError("seller_id", "seller does not exist")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, model.ErrEditConflict):
+		app.editConflictResponse(w, r)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/model"
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/validator"
+	"github.com/ainelnazaraly/CraftShop/pkg/money"
+	"github.com/gorilla/mux"
+)
+
+func (app *application) createCurrencyHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code     string `json:"code"`
+		Name     string `json:"name"`
+		Decimals *int   `json:"decimals"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	currency := &model.Currency{
+		Code:     strings.ToUpper(input.Code),
+		Name:     input.Name,
+		Decimals: 2,
+	}
+
+	if input.Decimals != nil {
+		currency.Decimals = *input.Decimals
+	}
+
+	v := validator.New()
+
+	if model.ValidateCurrency(v, currency); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Currencies.Insert(currency)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrDuplicateCurrency):
+			v.AddError("code", "this currency already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"currency": currency}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listCurrenciesHandler(w http.ResponseWriter, r *http.Request) {
+	currencies, err := app.models.Currencies.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"base_currency": money.DefaultCurrency, "currencies": currencies}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showCurrencyHandler returns a currency with the rate in effect at the time given by the at
+// query parameter, which defaults to now, so past conversions can be reproduced.
+func (app *application) showCurrencyHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	at := time.Now()
+
+	if s := r.URL.Query().Get("at"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			v.AddError("at", "must be a time in the RFC 3339 format")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		at = t
+	}
+
+	currency, err := app.models.Currencies.Get(currencyParam(r), at)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"currency": currency}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createExchangeRateHandler adds a rate to the history of a currency. It takes effect at
+// effective_at, or immediately if that is omitted.
+func (app *application) createExchangeRateHandler(w http.ResponseWriter, r *http.Request) {
+	currency, ok := app.readCurrency(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Rate        string     `json:"rate"`
+		EffectiveAt *time.Time `json:"effective_at"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	now := time.Now()
+
+	rate := &model.ExchangeRate{
+		Currency:    currency.Code,
+		Rate:        input.Rate,
+		EffectiveAt: now,
+	}
+
+	if input.EffectiveAt != nil {
+		rate.EffectiveAt = *input.EffectiveAt
+	}
+
+	v := validator.New()
+
+	if model.ValidateExchangeRate(v, rate, now); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Currencies.InsertRate(rate)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"exchange_rate": rate}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listExchangeRatesHandler(w http.ResponseWriter, r *http.Request) {
+	currency, ok := app.readCurrency(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		model.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = "-effective_at"
+
+	input.Filters.SortSafeList = []string{"-effective_at"}
+
+	if model.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	rates, metadata, err := app.models.Currencies.GetRates(currency.Code, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"exchange_rates": rates, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readCurrency reads the currency of the code route parameter, sending a 404 Not Found response
+// if it doesn't exist.
+func (app *application) readCurrency(w http.ResponseWriter, r *http.Request) (*model.Currency, bool) {
+	currency, err := app.models.Currencies.Get(currencyParam(r), time.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return currency, true
+}
+
+// currencyParam returns the code route parameter in upper case.
+func currencyParam(r *http.Request) string {
+	return strings.ToUpper(mux.Vars(r)["code"])
+}
+
+// readDisplayCurrency returns the exchange rate to the currency the client asked prices to be
+// shown in, with the currency query parameter or else the Accept-Currency header. The rate is
+// nil if no currency or the base currency was asked for. If the currency isn't supported a 422
+// response is sent.
+func (app *application) readDisplayCurrency(w http.ResponseWriter, r *http.Request) (*model.ExchangeRate, bool) {
+	w.Header().Add("Vary", "Accept-Currency")
+
+	code := r.URL.Query().Get("currency")
+	if code == "" {
+		code = r.Header.Get("Accept-Currency")
+	}
+	code = strings.ToUpper(strings.TrimSpace(code))
+
+	if code == "" || code == money.DefaultCurrency {
+		return nil, true
+	}
+
+	v := validator.New()
+
+	if !validator.Matches(code, model.CurrencyRX) {
+		v.AddError("currency", "must be an ISO 4217 currency code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+
+	rate, err := app.models.Currencies.RateAt(code, time.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			v.AddError("currency", "is not supported")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return rate, true
+}
+
+// convertProductPrices converts the prices of the products with the rate returned by
+// readDisplayCurrency. A nil rate leaves the products untouched.
+func convertProductPrices(rate *model.ExchangeRate, products ...*model.Product) error {
+	if rate == nil {
+		return nil
+	}
+
+	for _, product := range products {
+		if err := product.ConvertPrices(rate); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -109,4 +109,32 @@ func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.R
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
-}
\ No newline at end of file
+}
+
+// insufficientStockResponse sends a JSON-formatted error message to the client with a 409 Conflict
+// status code when a product doesn't have enough stock left.
+func (app *application) insufficientStockResponse(w http.ResponseWriter, r *http.Request) {
+	message := "not enough stock available to complete the request"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+// uploadTooLargeResponse sends a JSON-formatted error message to the client with a 413 Request
+// Entity Too Large status code.
+func (app *application) uploadTooLargeResponse(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("uploaded file must not be larger than %d bytes", app.config.storage.maxUploadBytes)
+	app.errorResponse(w, r, http.StatusRequestEntityTooLarge, message)
+}
+
+// unsupportedMediaTypeResponse sends a JSON-formatted error message to the client with a 415
+// Unsupported Media Type status code.
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, contentType string) {
+	message := fmt.Sprintf("files of type %s are not supported", contentType)
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
+
+// paymentDeclinedResponse sends a JSON-formatted error message to the client with a 402 Payment
+// Required status code when the payment gateway declines a payment.
+func (app *application) paymentDeclinedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the payment was declined"
+	app.errorResponse(w, r, http.StatusPaymentRequired, message)
+}
@@ -9,23 +9,26 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/model"
 	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/validator"
+	"github.com/ainelnazaraly/CraftShop/pkg/money"
 	"github.com/gorilla/mux"
 )
 
 // Define an envelope type.
 type envelope map[string]interface{}
 
-// readIDParam reads interpolated "id" from request URL and returns it and nil. If there is an error
-// it returns and 0 and an error.
-func (app *application) readIDParam(r *http.Request) (int, error) {
+// readIDParam reads the interpolated id parameter with the given name (e.g. "product_id") from
+// request URL and returns it and nil. If there is an error it returns and 0 and an error.
+func (app *application) readIDParam(r *http.Request, name string) (int, error) {
 	vars := mux.Vars(r)
-	param := vars["id"]
+	param := vars[name]
 
 	id, err := strconv.Atoi(param)
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 
 	return id, nil
@@ -135,6 +138,11 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 		case err.Error() == "http: request body too large":
 			return fmt.Errorf("body must not be larger than %d bytes", maxBytes)
 
+		// Amounts of money are decoded by money.Money, which rejects anything that isn't a
+		// decimal number with at most two decimal places.
+		case errors.Is(err, money.ErrInvalidFormat), errors.Is(err, money.ErrTooPrecise):
+			return fmt.Errorf("body contains an invalid amount of money: %w", err)
+
 		// A json.InvalidUnmarshalError error will be returned if we pass a non-nil
 		// pointer to Decode(). We catch this and panic, rather than returning an error
 		// to our handler. At the end of this chapter we'll talk about panicking
@@ -198,3 +206,136 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	// Otherwise, return the converted integer value.
 	return i
 }
+
+// readFloat is a helper method on application type that reads a string value from the URL query
+// string and converts it to a float before returning. If no matching key is found then it returns
+// the provided default value. If the value couldn't be converted to a float, then we record an
+// error message in the provided Validator instance, and return the default value.
+func (app *application) readFloat(qs url.Values, key string, defaultValue float64, v *validator.Validator) float64 {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		v.AddError(key, "must be a number")
+		return defaultValue
+	}
+
+	return f
+}
+
+// readMoney reads a decimal amount of DefaultCurrency such as "12.50" from the URL query string.
+// If no matching key is found then it returns the zero amount. If the value isn't a valid amount,
+// then we record an error message in the provided Validator instance, and return the zero amount.
+func (app *application) readMoney(qs url.Values, key string, v *validator.Validator) money.Money {
+	s := qs.Get(key)
+
+	if s == "" {
+		return money.New(0, money.DefaultCurrency)
+	}
+
+	m, err := money.Parse(s, money.DefaultCurrency)
+	if err != nil {
+		v.AddError(key, err.Error())
+		return money.New(0, money.DefaultCurrency)
+	}
+
+	return m
+}
+
+// readBool is a helper method on application type that reads a string value from the URL query
+// string and converts it to a bool before returning. If no matching key is found then it returns
+// the provided default value. If the value couldn't be converted to a bool, then we record an
+// error message in the provided Validator instance, and return the default value.
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+
+	return b
+}
+
+// readDate is a helper method on application type that reads a date in the YYYY-MM-DD format from
+// the URL query string. If no matching key is found then it returns the provided default value. If
+// the value couldn't be parsed, then we record an error message in the provided Validator
+// instance, and return the default value.
+func (app *application) readDate(qs url.Values, key string, defaultValue time.Time, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		v.AddError(key, "must be a date in the YYYY-MM-DD format")
+		return defaultValue
+	}
+
+	return t
+}
+
+// userHasPermission reports whether the user of the request has been granted the permission with
+// the given code.
+func (app *application) userHasPermission(r *http.Request, code string) (bool, error) {
+	user := app.contextGetUser(r)
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return false, err
+	}
+
+	return permissions.Include(code), nil
+}
+
+// canManageSeller reports whether the user of the request owns the seller profile, or has the
+// permission with the given code which allows managing the data of every seller.
+func (app *application) canManageSeller(r *http.Request, seller *model.Seller, code string) (bool, error) {
+	user := app.contextGetUser(r)
+
+	if seller.UserID != 0 && seller.UserID == user.ID {
+		return true, nil
+	}
+
+	return app.userHasPermission(r, code)
+}
+
+// background runs fn in a goroutine tracked by app.wg, so that a graceful shutdown waits for it to
+// finish. A panic in fn is recovered and logged instead of bringing down the server.
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.PrintError(fmt.Errorf("%s", err), nil)
+			}
+		}()
+
+		fn()
+	}()
+}
+
+// sendEmail sends an email in the background. A failed delivery is only logged, since the
+// response has usually been sent by then.
+func (app *application) sendEmail(recipient, templateFile string, data map[string]interface{}) {
+	app.background(func() {
+		err := app.mailer.Send(recipient, templateFile, data)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"template": templateFile})
+		}
+	})
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"time"
+
+	// Register the GIF and PNG decoders with image.Decode; JPEG is registered by image/jpeg.
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/model"
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/validator"
+	"github.com/ainelnazaraly/CraftShop/pkg/thumbnail"
+)
+
+func (app *application) uploadProductImageHandler(w http.ResponseWriter, r *http.Request) {
+	product, ok := app.readOwnProduct(w, r)
+	if !ok {
+		return
+	}
+
+	productID := product.ID
+
+	data, err := app.readImageUpload(w, r, "image")
+	if err != nil {
+		switch {
+		case errors.Is(err, errUploadTooLarge):
+			app.uploadTooLargeResponse(w, r)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	// Trust the content of the file rather than the Content-Type sent by the client.
+	contentType := http.DetectContentType(data)
+	if !model.AllowedImageType(contentType) {
+		app.unsupportedMediaTypeResponse(w, r, contentType)
+		return
+	}
+
+	// Read the dimensions from the header first, so that an image which would take too much
+	// memory to decode is rejected before it is decoded.
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		v := validator.New()
+		v.AddError("image", "could not be decoded")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if config.Width <= 0 || config.Height <= 0 || int64(config.Width)*int64(config.Height) > model.MaxImagePixels {
+		v := validator.New()
+		v.AddError("image", fmt.Sprintf("must not have more than %d pixels", model.MaxImagePixels))
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		v := validator.New()
+		v.AddError("image", "could not be decoded")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	key, err := randomKey()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	productImage := &model.ProductImage{
+		ProductID:   productID,
+		StorageKey:  fmt.Sprintf("products/%d/%s", productID, key),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		Width:       img.Bounds().Dx(),
+		Height:      img.Bounds().Dy(),
+	}
+
+	err = app.storeProductImage(r.Context(), productImage, data, img)
+	if err != nil {
+		app.deleteStoredImage(productImage)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.ProductImages.Insert(productImage)
+	if err != nil {
+		app.deleteStoredImage(productImage)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.setImageURLs(productImage)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"image": productImage}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listProductImagesHandler(w http.ResponseWriter, r *http.Request) {
+	productID, err := app.readIDParam(r, "product_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	product, err := app.models.Products.Get(productID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.attachProductImages(product)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"images": product.Images}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// reorderProductImagesHandler sets the display order of the images of a product. The request
+// must list the ids of all images of the product.
+func (app *application) reorderProductImagesHandler(w http.ResponseWriter, r *http.Request) {
+	product, ok := app.readOwnProduct(w, r)
+	if !ok {
+		return
+	}
+
+	productID := product.ID
+
+	var input struct {
+		ImageIDs []int64 `json:"image_ids"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	err = app.attachProductImages(product)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	existing := make(map[int64]bool, len(product.Images))
+	for _, image := range product.Images {
+		existing[image.ID] = true
+	}
+
+	v := validator.New()
+
+	seen := make(map[int64]bool, len(input.ImageIDs))
+	for _, id := range input.ImageIDs {
+		v.Check(existing[id] && !seen[id], "image_ids", "must list every image of the product exactly once")
+		seen[id] = true
+	}
+	v.Check(len(input.ImageIDs) == len(product.Images), "image_ids", "must list every image of the product exactly once")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.ProductImages.Reorder(productID, input.ImageIDs)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.attachProductImages(product)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"images": product.Images}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteProductImageHandler(w http.ResponseWriter, r *http.Request) {
+	product, ok := app.readOwnProduct(w, r)
+	if !ok {
+		return
+	}
+
+	productID := product.ID
+
+	id, err := app.readIDParam(r, "image_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	productImage, err := app.models.ProductImages.Get(productID, int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.ProductImages.Delete(productImage.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.deleteStoredImage(productImage)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "image successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+var errUploadTooLarge = errors.New("upload too large")
+
+// readImageUpload reads the file sent in the given field of a multipart/form-data request. The
+// request body is limited to app.config.storage.maxUploadBytes plus some room for the rest of the
+// multipart encoding, and errUploadTooLarge is returned if the file is bigger than the limit.
+func (app *application) readImageUpload(w http.ResponseWriter, r *http.Request, field string) ([]byte, error) {
+	maxBytes := app.config.storage.maxUploadBytes
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+1_048_576)
+
+	file, _, err := r.FormFile(field)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			return nil, errUploadTooLarge
+		case errors.Is(err, http.ErrMissingFile):
+			return nil, fmt.Errorf("request must contain a file in the %q field", field)
+		default:
+			return nil, err
+		}
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) > maxBytes {
+		return nil, errUploadTooLarge
+	}
+
+	return data, nil
+}
+
+// storeProductImage writes the original upload and all its thumbnails to the storage. Every
+// thumbnail is scaled down from the previous, larger one to keep the work small.
+func (app *application) storeProductImage(ctx context.Context, productImage *model.ProductImage, data []byte, img image.Image) error {
+	err := app.storage.Put(ctx, productImage.OriginalKey(), bytes.NewReader(data), productImage.ContentType)
+	if err != nil {
+		return err
+	}
+
+	src := img
+
+	for _, size := range model.ThumbnailSizes {
+		thumb := thumbnail.Resize(src, size.MaxSide)
+
+		var buf bytes.Buffer
+
+		err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85})
+		if err != nil {
+			return err
+		}
+
+		err = app.storage.Put(ctx, productImage.ThumbnailKey(size.Name), &buf, "image/jpeg")
+		if err != nil {
+			return err
+		}
+
+		src = thumb
+	}
+
+	return nil
+}
+
+// deleteStoredImage removes the original and the thumbnails of an image from the storage.
+// Failures are only logged, as the database record is the source of truth.
+func (app *application) deleteStoredImage(productImage *model.ProductImage) {
+	app.deleteStoredFile(productImage.Keys()...)
+}
+
+// deleteStoredFile removes files from the storage, logging any failures.
+func (app *application) deleteStoredFile(keys ...string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, key := range keys {
+		err := app.storage.Delete(ctx, key)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"storage_key": key})
+		}
+	}
+}
+
+// attachProductImages loads the images of the given products with a single query and fills in
+// their URLs.
+func (app *application) attachProductImages(products ...*model.Product) error {
+	ids := make([]int, len(products))
+	for i, product := range products {
+		ids[i] = product.ID
+	}
+
+	images, err := app.models.ProductImages.GetAllForProducts(ids)
+	if err != nil {
+		return err
+	}
+
+	for _, product := range products {
+		product.Images = images[product.ID]
+		if product.Images == nil {
+			product.Images = []*model.ProductImage{}
+		}
+
+		for _, productImage := range product.Images {
+			app.setImageURLs(productImage)
+		}
+	}
+
+	return nil
+}
+
+func (app *application) setImageURLs(productImage *model.ProductImage) {
+	productImage.URL = app.storage.URL(productImage.OriginalKey())
+	productImage.Thumbnails = make(map[string]string, len(model.ThumbnailSizes))
+
+	for _, size := range model.ThumbnailSizes {
+		productImage.Thumbnails[size.Name] = app.storage.URL(productImage.ThumbnailKey(size.Name))
+	}
+}
+
+// randomKey returns a random hex string which is used to make storage keys unguessable.
+func randomKey() (string, error) {
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
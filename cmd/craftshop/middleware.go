@@ -65,7 +65,9 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 
 		// Call the contextSetUser healer to add the user information to the request context.
+		// The token is kept as well, so that it can be revoked on logout.
 		r = app.contextSetUser(r, user)
+		r = app.contextSetToken(r, token)
 
 		// Call next handler in chain
 		next.ServeHTTP(w, r)
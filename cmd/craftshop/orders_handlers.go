@@ -0,0 +1,281 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/model"
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/validator"
+)
+
+func (app *application) createOrderHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Items []struct {
+			ProductID int `json:"product_id"`
+			Quantity  int `json:"quantity"`
+		} `json:"items"`
+		CouponCode string `json:"coupon_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	order := &model.Order{
+		UserID:     user.ID,
+		CouponCode: input.CouponCode,
+	}
+
+	for _, item := range input.Items {
+		order.Items = append(order.Items, &model.OrderItem{
+			ProductID: item.ProductID,
+			Quantity:  item.Quantity,
+		})
+	}
+
+	v := validator.New()
+
+	if model.ValidateOrder(v, order); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Orders.Insert(order)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			v.AddError("items", "one or more products do not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, model.ErrInsufficientStock):
+			app.insufficientStockResponse(w, r)
+		case errors.Is(err, model.ErrCouponNotApplicable):
+			v.AddError("coupon_code", err.Error())
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"order": order}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Status string
+		model.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Status = app.readString(qs, "status", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+
+	input.Filters.SortSafeList = []string{"id", "created_at", "total", "status", "-id", "-created_at", "-total", "-status"}
+
+	if input.Status != "" {
+		v.Check(model.ValidOrderStatus(input.Status), "status", "invalid status value")
+	}
+
+	if model.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	orders, metadata, err := app.models.Orders.GetAllForUser(user.ID, input.Status, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"orders": orders, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listSellerOrdersHandler returns the orders which contain products of a seller, with only the
+// items of that seller. It is available to the owner of the seller profile and to users with the
+// "sellers:write" permission.
+func (app *application) listSellerOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	seller, ok := app.readOwnSeller(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Status string
+		model.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Status = app.readString(qs, "status", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+
+	input.Filters.SortSafeList = []string{"id", "created_at", "status", "-id", "-created_at", "-status"}
+
+	if input.Status != "" {
+		v.Check(model.ValidOrderStatus(input.Status), "status", "invalid status value")
+	}
+
+	if model.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	orders, metadata, err := app.models.Orders.GetAllForSeller(seller.SellerID, input.Status, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"orders": orders, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showOrderHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r, "order_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	order, err := app.models.Orders.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Besides the buyer, the sellers of the products in the order may read it, but they only see
+	// their own items. Orders of other users are reported as not found rather than forbidden, so
+	// that clients can't probe which order ids exist.
+	user := app.contextGetUser(r)
+	if order.UserID != user.ID {
+		seller, err := app.models.Sellers.GetForUser(user.ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, model.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if !order.KeepSellerItems(seller.SellerID) {
+			app.notFoundResponse(w, r)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"order": order}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateOrderStatusHandler moves an order through its state machine. The buyer may cancel their
+// own order while it is pending; once it is paid, cancelling it would need a refund, so every
+// other transition requires the "orders:write" permission.
+func (app *application) updateOrderStatusHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r, "order_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Status string `json:"status"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(model.ValidOrderStatus(input.Status), "status", "invalid status value"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	order, err := app.models.Orders.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !permissions.Include("orders:write") {
+		if order.UserID != user.ID {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		if input.Status != model.OrderStatusCancelled || order.Status != model.OrderStatusPending {
+			app.notPermittedResponse(w, r)
+			return
+		}
+	}
+
+	err = app.models.Orders.UpdateStatus(order, input.Status, user.ID, app.config.ledger.commissionBPS)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrInvalidTransition):
+			v.AddError("status", "cannot change from "+order.Status+" to "+input.Status)
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Reload the order so that the response contains the new history entry.
+	order, err = app.models.Orders.Get(order.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"order": order}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
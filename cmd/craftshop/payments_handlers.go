@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/model"
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/validator"
+	"github.com/ainelnazaraly/CraftShop/pkg/payments"
+)
+
+// buyProductHandler starts a buy-now purchase of a product: the payment is authorized and then
+// captured at the payment gateway. The gateway confirms the capture through the webhook, so the
+// payment in the response is still authorized. The quantity is taken from the stock when the
+// payment is created and put back if the payment is declined or fails.
+func (app *application) buyProductHandler(w http.ResponseWriter, r *http.Request) {
+	productID, err := app.readIDParam(r, "product_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Quantity int `json:"quantity"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	product, err := app.models.Products.Get(productID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	payment := &model.Payment{
+		UserID:    user.ID,
+		ProductID: product.ID,
+		SellerID:  product.SellerID,
+		Quantity:  input.Quantity,
+		Amount:    product.Price.Mul(input.Quantity).Amount,
+		Currency:  product.Price.Currency,
+		Provider:  app.payments.Name(),
+	}
+
+	v := validator.New()
+
+	if model.ValidatePayment(v, payment); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Payments.Insert(payment)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrInsufficientStock):
+			app.insufficientStockResponse(w, r)
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	intentID, err := app.payments.Authorize(r.Context(), payment.Amount, payment.Currency, strconv.FormatInt(payment.ID, 10))
+	if err != nil {
+		switch {
+		case errors.Is(err, payments.ErrDeclined):
+			err = app.models.Payments.UpdateStatus(payment, model.PaymentStatusFailed, "", err.Error())
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			app.paymentDeclinedResponse(w, r)
+		default:
+			app.failPayment(r, payment, err)
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Payments.UpdateStatus(payment, model.PaymentStatusAuthorized, intentID, "")
+	if err != nil {
+		app.failPayment(r, payment, err)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.payments.Capture(r.Context(), intentID, payment.Amount)
+	if err != nil {
+		app.failPayment(r, payment, err)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"payment": payment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// failPayment marks a payment which couldn't be completed as failed, so that the stock it took is
+// put back. cause is kept as the failure reason. Errors are only logged, since the caller is
+// already reporting cause.
+func (app *application) failPayment(r *http.Request, payment *model.Payment, cause error) {
+	err := app.models.Payments.UpdateStatus(payment, model.PaymentStatusFailed, "", cause.Error())
+	if err != nil {
+		app.logError(r, fmt.Errorf("payment %d: %w", payment.ID, err))
+	}
+}
+
+func (app *application) showPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r, "payment_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	payment, err := app.models.Payments.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Payments of other users are reported as not found, like orders.
+	user := app.contextGetUser(r)
+	if payment.UserID != user.ID {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"payment": payment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// paymentWebhookHandler receives the notifications of the payment gateway and advances the state
+// of the payment. Events delivered more than once are acknowledged without being applied again.
+// Unknown intents get a 404 response so that the gateway retries the event later.
+func (app *application) paymentWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 65_536))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	event, err := app.payments.VerifyWebhook(r.Header, body)
+	if err != nil {
+		switch {
+		case errors.Is(err, payments.ErrInvalidSignature):
+			app.errorResponse(w, r, http.StatusUnauthorized, "invalid webhook signature")
+		case errors.Is(err, payments.ErrInvalidEvent):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	payment, err := app.models.Payments.ApplyEvent(app.payments.Name(), event, app.config.ledger.commissionBPS)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrDuplicateEvent):
+			err = app.writeJSON(w, http.StatusOK, envelope{"message": "event already processed"}, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrEventMismatch):
+			app.logError(r, fmt.Errorf("payment %d: %w", payment.ID, err))
+			app.errorResponse(w, r, http.StatusUnprocessableEntity, "the event amount or currency doesn't match the payment")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "event processed", "status": payment.Status}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
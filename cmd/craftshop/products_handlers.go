@@ -1,46 +1,121 @@
 package main
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/model"
 	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/validator"
+	"github.com/ainelnazaraly/CraftShop/pkg/money"
 	"github.com/gorilla/mux"
 )
 
+// productVariantInput is the JSON accepted for a variant in product create and update requests.
+type productVariantInput struct {
+	SKU     string            `json:"sku"`
+	Options map[string]string `json:"options"`
+	Price   *money.Money      `json:"price"`
+	Stock   int               `json:"stock"`
+}
+
+// variantsFromInput converts the variants of a request body. A null variant stays nil, so that
+// the validation of the product reports it.
+func variantsFromInput(inputs []*productVariantInput) []*model.ProductVariant {
+	variants := make([]*model.ProductVariant, len(inputs))
+
+	for i, input := range inputs {
+		if input == nil {
+			continue
+		}
+
+		variants[i] = &model.ProductVariant{
+			SKU:     input.SKU,
+			Options: input.Options,
+			Price:   input.Price,
+			Stock:   input.Stock,
+		}
+	}
+
+	return variants
+}
+
 func (app *application) createProductHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Name            string  `json:"product_name"`
-		Description     string  `json:"description"`
-		Price           float64 `json:"price"`
-		Category        string  `json:"category"`
-		MaterialsUsed   string  `json:"materials_used"`
-		ShippingDetails string  `json:"shipping_details"`
-		SellerID        int     `json:"seller_id"`
+		Name              string                 `json:"product_name"`
+		Description       string                 `json:"description"`
+		Price             money.Money            `json:"price"`
+		Category          string                 `json:"category"`
+		MaterialsUsed     string                 `json:"materials_used"`
+		ShippingNote      string                 `json:"shipping_note"`
+		ShippingDetails   string                 `json:"shipping_details"`
+		ShippingProfileID int64                  `json:"shipping_profile_id"`
+		WeightGrams       int                    `json:"weight_grams"`
+		SellerID          int                    `json:"seller_id"`
+		Stock             int                    `json:"stock"`
+		Options           []*model.ProductOption `json:"options"`
+		Variants          []*productVariantInput `json:"variants"`
 	}
 	err := app.readJSON(w, r, &input)
 	if err != nil {
-		app.respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// shipping_details is the old name of shipping_note, still accepted from existing clients.
+	if input.ShippingNote == "" {
+		input.ShippingNote = input.ShippingDetails
+	}
+
+	sellerID, ok := app.productSellerID(w, r, input.SellerID)
+	if !ok {
 		return
 	}
 
 	product := &model.Product{
-		Name:            input.Name,
-		Description:     input.Description,
-		Price:           input.Price,
-		Category:        input.Category,
-		MaterialsUsed:   input.MaterialsUsed,
-		ShippingDetails: input.ShippingDetails,
-		SellerID:        input.SellerID,
+		Name:              input.Name,
+		Description:       input.Description,
+		Price:             input.Price,
+		Category:          input.Category,
+		MaterialsUsed:     input.MaterialsUsed,
+		ShippingNote:      input.ShippingNote,
+		ShippingProfileID: input.ShippingProfileID,
+		WeightGrams:       input.WeightGrams,
+		SellerID:          sellerID,
+		Stock:             input.Stock,
+		Options:           input.Options,
+		Variants:          variantsFromInput(input.Variants),
+	}
+
+	v := validator.New()
+
+	model.ValidateProduct(v, product)
+
+	err = app.validateProductShippingProfile(v, product)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
 	}
 
 	err = app.models.Products.Insert(product)
 	if err != nil {
-		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server error")
+		switch {
+		case errors.Is(err, model.ErrDuplicateSKU):
+			v.AddError("variants", "a sku is already used by another product")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server error")
+		}
 		return
 	}
 
+	product.Images = []*model.ProductImage{}
+
 	app.respondWithJSON(w, http.StatusCreated, product)
 }
 
@@ -54,44 +129,56 @@ func (app *application) getProductHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	rate, ok := app.readDisplayCurrency(w, r)
+	if !ok {
+		return
+	}
+
 	product, err := app.models.Products.Get(id)
 	if err != nil {
 		app.respondWithError(w, http.StatusNotFound, "404 not found")
 		return
 	}
 
-	app.respondWithJSON(w, http.StatusOK, product)
-}
-
-func (app *application) updateProductHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	param := vars["product_id"]
-
-	id, err := strconv.Atoi(param)
-	if err != nil || id < 1 {
-		app.respondWithError(w, http.StatusBadRequest, "Invalid product ID")
+	err = app.attachProductImages(product)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	product, err := app.models.Products.Get(id)
+	err = convertProductPrices(rate, product)
 	if err != nil {
-		app.respondWithError(w, http.StatusNotFound, "404 not found")
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, product)
+}
+
+func (app *application) updateProductHandler(w http.ResponseWriter, r *http.Request) {
+	product, ok := app.readOwnProduct(w, r)
+	if !ok {
 		return
 	}
 
 	var input struct {
-		Name            *string  `json:"product_name"`
-		Description     *string  `json:"description"`
-		Price           *float64 `json:"price"`
-		Category        *string  `json:"category"`
-		MaterialsUsed   *string  `json:"materials_used"`
-		ShippingDetails *string  `json:"shipping_details"`
-		SellerID        *int     `json:"seller_id"`
+		Name              *string                `json:"product_name"`
+		Description       *string                `json:"description"`
+		Price             *money.Money           `json:"price"`
+		Category          *string                `json:"category"`
+		MaterialsUsed     *string                `json:"materials_used"`
+		ShippingNote      *string                `json:"shipping_note"`
+		ShippingDetails   *string                `json:"shipping_details"`
+		ShippingProfileID *int64                 `json:"shipping_profile_id"`
+		WeightGrams       *int                   `json:"weight_grams"`
+		SellerID          *int                   `json:"seller_id"`
+		Options           []*model.ProductOption `json:"options"`
+		Variants          []*productVariantInput `json:"variants"`
 	}
-	err = app.readJSON(w, r, &input)
+	err := app.readJSON(w, r, &input)
 
 	if err != nil {
-		app.respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		app.badRequestResponse(w, r, err)
 		return
 	}
 
@@ -115,17 +202,69 @@ func (app *application) updateProductHandler(w http.ResponseWriter, r *http.Requ
 		product.MaterialsUsed = *input.MaterialsUsed
 	}
 
-	if input.ShippingDetails != nil {
-		product.ShippingDetails = *input.ShippingDetails
+	// shipping_details is the old name of shipping_note, still accepted from existing clients.
+	if input.ShippingNote == nil {
+		input.ShippingNote = input.ShippingDetails
+	}
+
+	if input.ShippingNote != nil {
+		product.ShippingNote = *input.ShippingNote
+	}
+
+	if input.ShippingProfileID != nil {
+		product.ShippingProfileID = *input.ShippingProfileID
+	}
+
+	if input.WeightGrams != nil {
+		product.WeightGrams = *input.WeightGrams
+	}
+
+	if input.SellerID != nil && *input.SellerID != product.SellerID {
+		product.SellerID, ok = app.productSellerID(w, r, *input.SellerID)
+		if !ok {
+			return
+		}
+	}
+
+	// Options and variants are replaced as a whole when they are present in the request.
+	if input.Options != nil {
+		product.Options = input.Options
 	}
 
-	if input.SellerID != nil {
-		product.SellerID = *input.SellerID
+	if input.Variants != nil {
+		product.Variants = variantsFromInput(input.Variants)
+	}
+
+	v := validator.New()
+
+	model.ValidateProduct(v, product)
+
+	err = app.validateProductShippingProfile(v, product)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
 	}
 
 	err = app.models.Products.Update(product)
 	if err != nil {
-		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		switch {
+		case errors.Is(err, model.ErrDuplicateSKU):
+			v.AddError("variants", "a sku is already used by another product")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		}
+		return
+	}
+
+	err = app.attachProductImages(product)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 
@@ -133,16 +272,12 @@ func (app *application) updateProductHandler(w http.ResponseWriter, r *http.Requ
 }
 
 func (app *application) deleteProductHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	param := vars["product_id"]
-
-	id, err := strconv.Atoi(param)
-	if err != nil || id < 1 {
-		app.respondWithError(w, http.StatusBadRequest, "Invalid product ID")
+	product, ok := app.readOwnProduct(w, r)
+	if !ok {
 		return
 	}
 
-	err = app.models.Products.Delete(id)
+	err := app.models.Products.Delete(product.ID)
 	if err != nil {
 		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
 		return
@@ -153,25 +288,58 @@ func (app *application) deleteProductHandler(w http.ResponseWriter, r *http.Requ
 
 func (app *application) listProductsHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Category string
 		model.Filters
 	}
 	v := validator.New()
 	qs := r.URL.Query()
 
-	input.Category = app.readString(qs, "category", "")
+	input.Filters.Category = app.readString(qs, "category", "")
+	input.Filters.Search = app.readString(qs, "q", "")
+	input.Filters.MinStock = app.readInt(qs, "min_stock", 0, v)
+	// in_stock=true is a shorthand for min_stock=1.
+	if app.readBool(qs, "in_stock", false, v) && input.Filters.MinStock < 1 {
+		input.Filters.MinStock = 1
+	}
+	input.Filters.MinPrice = app.readMoney(qs, "min_price", v)
+	input.Filters.MaxPrice = app.readMoney(qs, "max_price", v)
+	input.Filters.Materials = app.readString(qs, "materials", "")
+	input.Filters.Location = app.readString(qs, "location", "")
+	input.Filters.MinRating = app.readFloat(qs, "min_rating", 0, v)
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
-	input.Filters.Sort = app.readString(qs, "sort", "product_id")
+	// Search results are ordered by relevance unless the client asks for something else.
+	defaultSort := "product_id"
+	if input.Filters.Search != "" {
+		defaultSort = "-rank"
+	}
+	input.Filters.Sort = app.readString(qs, "sort", defaultSort)
 
-	input.Filters.SortSafeList = []string{"product_id", "price", "category", "-product_id", "-price", "-category"}
+	input.Filters.SortSafeList = []string{"product_id", "price", "category", "stock", "rating_average", "rating_count", "favourited_count",
+		"-product_id", "-price", "-category", "-stock", "-rating_average", "-rating_count", "-favourited_count", "-rank"}
 
 	if model.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	products, metadata, err := app.models.Products.GetAll(input.Category, input.Filters)
+	rate, ok := app.readDisplayCurrency(w, r)
+	if !ok {
+		return
+	}
+
+	products, metadata, err := app.models.Products.GetAll(input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.attachProductImages(products...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = convertProductPrices(rate, products...)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -195,6 +363,11 @@ func (app *application) listProductsBySellerHandler(w http.ResponseWriter, r *ht
 		return
 	}
 
+	rate, ok := app.readDisplayCurrency(w, r)
+	if !ok {
+		return
+	}
+
 	// Retrieve products by seller ID
 	products, err := app.models.Products.GetBySellerID(sellerID)
 	if err != nil {
@@ -202,9 +375,189 @@ func (app *application) listProductsBySellerHandler(w http.ResponseWriter, r *ht
 		return
 	}
 
+	err = app.attachProductImages(products...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = convertProductPrices(rate, products...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	// Write products to response
 	err = app.writeJSON(w, http.StatusOK, envelope{"products": products}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// adjustProductStockHandler adds a positive delta to, or removes a negative delta from, the stock
+// of a product. Removing more than the unreserved stock results in a 409 Conflict response.
+func (app *application) adjustProductStockHandler(w http.ResponseWriter, r *http.Request) {
+	product, ok := app.readOwnProduct(w, r)
+	if !ok {
+		return
+	}
+
+	id := product.ID
+
+	var input struct {
+		Delta int `json:"delta"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Delta != 0, "delta", "must not be zero"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var stock int
+
+	if input.Delta > 0 {
+		stock, err = app.models.Products.IncrementStock(id, input.Delta)
+	} else {
+		stock, err = app.models.Products.DecrementStock(id, -input.Delta)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrInsufficientStock):
+			app.insufficientStockResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"product_id": id, "stock": stock}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getVariantHandler(w http.ResponseWriter, r *http.Request) {
+	sku := mux.Vars(r)["sku"]
+
+	variant, err := app.models.Products.GetVariant(sku)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"variant": variant}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readOwnProduct loads the product addressed by the request URL and checks that it belongs to the
+// seller profile of the current user, or that the user has the "products:write" permission. If
+// anything goes wrong the error response is sent and ok is false.
+func (app *application) readOwnProduct(w http.ResponseWriter, r *http.Request) (*model.Product, bool) {
+	id, err := app.readIDParam(r, "product_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	product, err := app.models.Products.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	// The foreign key on products.seller_id guarantees that the seller exists.
+	seller, err := app.models.Sellers.Get(product.SellerID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	ok, err := app.canManageSeller(r, seller, "products:write")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	if !ok {
+		app.notPermittedResponse(w, r)
+		return nil, false
+	}
+
+	return product, true
+}
+
+// productSellerID returns the seller which a product created or moved by the current user is
+// assigned to. Without a requested seller id this is the seller profile of the user; assigning a
+// product to any other seller requires the "products:write" permission. If anything goes wrong the
+// error response is sent and ok is false.
+func (app *application) productSellerID(w http.ResponseWriter, r *http.Request, requested int) (int, bool) {
+	user := app.contextGetUser(r)
+
+	seller, err := app.models.Sellers.GetForUser(user.ID)
+	if err != nil && !errors.Is(err, model.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return 0, false
+	}
+
+	if seller != nil && (requested == 0 || requested == seller.SellerID) {
+		return seller.SellerID, true
+	}
+
+	ok, err := app.userHasPermission(r, "products:write")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return 0, false
+	}
+
+	if !ok {
+		if seller == nil {
+			app.errorResponse(w, r, http.StatusForbidden, "you must create a seller profile before adding products")
+			return 0, false
+		}
+		app.notPermittedResponse(w, r)
+		return 0, false
+	}
+
+	v := validator.New()
+
+	if v.Check(requested > 0, "seller_id", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return 0, false
+	}
+
+	_, err = app.models.Sellers.Get(requested)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			v.AddError("seller_id", "seller does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return 0, false
+	}
+
+	return requested, true
+}
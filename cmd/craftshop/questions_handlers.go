@@ -0,0 +1,273 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/model"
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/validator"
+)
+
+func (app *application) createQuestionHandler(w http.ResponseWriter, r *http.Request) {
+	productID, err := app.readIDParam(r, "product_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Question string `json:"question"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	_, err = app.models.Products.Get(productID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	question := &model.Question{
+		ProductID: productID,
+		UserID:    user.ID,
+		UserName:  user.Name,
+		Question:  input.Question,
+	}
+
+	v := validator.New()
+
+	if model.ValidateQuestion(v, question); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Questions.Insert(question)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"question": question}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listQuestionsHandler returns the questions about a product. The answered query parameter
+// filters by whether a question has been answered. Hidden content is only included with
+// include_hidden, which requires the questions:moderate permission.
+func (app *application) listQuestionsHandler(w http.ResponseWriter, r *http.Request) {
+	productID, err := app.readIDParam(r, "product_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input model.QuestionFilters
+	v := validator.New()
+	qs := r.URL.Query()
+
+	if qs.Get("answered") != "" {
+		answered := app.readBool(qs, "answered", false, v)
+		input.Answered = &answered
+	}
+
+	input.IncludeHidden = app.readBool(qs, "include_hidden", false, v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+
+	input.Filters.SortSafeList = []string{"created_at", "answered_at", "-created_at", "-answered_at"}
+
+	if model.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if input.IncludeHidden {
+		if app.contextGetUser(r).IsAnonymous() {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+
+		ok, err := app.userHasPermission(r, "questions:moderate")
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !ok {
+			app.notPermittedResponse(w, r)
+			return
+		}
+	}
+
+	_, err = app.models.Products.Get(productID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	questions, metadata, err := app.models.Questions.GetAllForProduct(productID, input)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"questions": questions, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// answerQuestionHandler saves the answer of the seller of the product to a question. Answering
+// again replaces the answer. Hidden questions can't be answered.
+func (app *application) answerQuestionHandler(w http.ResponseWriter, r *http.Request) {
+	product, ok := app.readOwnProduct(w, r)
+	if !ok {
+		return
+	}
+
+	question, ok := app.readQuestion(w, r, product.ID)
+	if !ok {
+		return
+	}
+
+	if question.Hidden {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Answer string `json:"answer"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	question.Answer = input.Answer
+
+	v := validator.New()
+
+	if model.ValidateAnswer(v, question); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Questions.Answer(question, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"question": question}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// moderateQuestionHandler hides or shows a question, or only its answer.
+func (app *application) moderateQuestionHandler(w http.ResponseWriter, r *http.Request) {
+	productID, err := app.readIDParam(r, "product_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	question, ok := app.readQuestion(w, r, productID)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Hidden       *bool `json:"hidden"`
+		AnswerHidden *bool `json:"answer_hidden"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Hidden != nil || input.AnswerHidden != nil, "hidden", "hidden or answer_hidden must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if input.Hidden != nil {
+		question.Hidden = *input.Hidden
+	}
+
+	if input.AnswerHidden != nil {
+		question.AnswerHidden = *input.AnswerHidden
+	}
+
+	err = app.models.Questions.Moderate(question, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"question": question}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readQuestion loads the question of the question_id route parameter about the given product.
+// If anything goes wrong the error response is sent and ok is false.
+func (app *application) readQuestion(w http.ResponseWriter, r *http.Request, productID int) (*model.Question, bool) {
+	id, err := app.readIDParam(r, "question_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	question, err := app.models.Questions.Get(productID, int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return question, true
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/model"
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/validator"
+)
+
+// createReservationHandler holds back stock of a product for the current user for
+// model.ReservationTTL, so that it can't be sold to anyone else while they check out.
+func (app *application) createReservationHandler(w http.ResponseWriter, r *http.Request) {
+	productID, err := app.readIDParam(r, "product_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Quantity int `json:"quantity"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	reservation := &model.StockReservation{
+		ProductID: productID,
+		UserID:    user.ID,
+		Quantity:  input.Quantity,
+	}
+
+	v := validator.New()
+
+	if model.ValidateReservation(v, reservation); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Reservations.Insert(reservation, model.ReservationTTL)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrInsufficientStock):
+			app.insufficientStockResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"reservation": reservation}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteReservationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r, "reservation_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.Reservations.Delete(int64(id), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "reservation successfully released"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/model"
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/validator"
+)
+
+func (app *application) listPurchasesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		model.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+
+	input.Filters.SortSafeList = []string{"id", "created_at", "amount", "-id", "-created_at", "-amount"}
+
+	if model.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	purchases, metadata, err := app.models.Purchases.GetAllForUser(user.ID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"purchases": purchases, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createReturnHandler(w http.ResponseWriter, r *http.Request) {
+	purchaseID, err := app.readIDParam(r, "purchase_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	purchase, err := app.models.Purchases.Get(int64(purchaseID))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Purchases of other users are reported as not found, like orders.
+	user := app.contextGetUser(r)
+	if purchase.UserID != user.ID {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ret := &model.Return{
+		PurchaseID:  purchase.ID,
+		UserID:      user.ID,
+		Reason:      input.Reason,
+		Purchase:    purchase,
+		Attachments: []*model.ReturnAttachment{},
+	}
+
+	v := validator.New()
+
+	if model.ValidateReturn(v, ret); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Returns.Insert(ret)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrDuplicateReturn):
+			v.AddError("purchase_id", "a return has already been requested for this purchase")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"return": ret}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listReturnsHandler lists the returns the current user has requested as a buyer or received as
+// a seller.
+func (app *application) listReturnsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Status string
+		model.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Status = app.readString(qs, "status", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+
+	input.Filters.SortSafeList = []string{"id", "created_at", "status", "-id", "-created_at", "-status"}
+
+	if input.Status != "" {
+		v.Check(model.ValidReturnStatus(input.Status), "status", "invalid status value")
+	}
+
+	if model.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	returns, metadata, err := app.models.Returns.GetAllForUser(user.ID, input.Status, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"returns": returns, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showReturnHandler(w http.ResponseWriter, r *http.Request) {
+	ret, _, ok := app.readReturn(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"return": ret}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// uploadReturnAttachmentHandler attaches a photo or PDF to an open return of the current user.
+func (app *application) uploadReturnAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	ret, _, ok := app.readReturn(w, r)
+	if !ok {
+		return
+	}
+
+	user := app.contextGetUser(r)
+	if ret.UserID != user.ID {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(ret.Open(), "status", "files can't be attached to a "+ret.Status+" return")
+	v.Check(len(ret.Attachments) < model.MaxReturnAttachments, "file", fmt.Sprintf("must not attach more than %d files", model.MaxReturnAttachments))
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	data, err := app.readImageUpload(w, r, "file")
+	if err != nil {
+		switch {
+		case errors.Is(err, errUploadTooLarge):
+			app.uploadTooLargeResponse(w, r)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	// Trust the content of the file rather than the Content-Type sent by the client.
+	contentType := http.DetectContentType(data)
+	ext, ok := model.AttachmentExtension(contentType)
+	if !ok {
+		app.unsupportedMediaTypeResponse(w, r, contentType)
+		return
+	}
+
+	key, err := randomKey()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	attachment := &model.ReturnAttachment{
+		ReturnID:    ret.ID,
+		StorageKey:  fmt.Sprintf("returns/%d/%s%s", ret.ID, key, ext),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+	}
+
+	err = app.storage.Put(r.Context(), attachment.StorageKey, bytes.NewReader(data), contentType)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Returns.InsertAttachment(attachment)
+	if err != nil {
+		app.deleteStoredFile(attachment.StorageKey)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	attachment.URL = app.storage.URL(attachment.StorageKey)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"attachment": attachment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateReturnStatusHandler returns a handler with which the seller of the purchased product
+// moves a return to status, e.g. approves or rejects it. The request body may contain a note for
+// the audit trail. Refunding a purchase paid through the payment gateway refunds the payment;
+// the gateway confirms the refund through the payment webhook. The new status is saved before the
+// gateway is asked for the refund, so that concurrent requests can't refund twice. If the gateway
+// fails the refund can be requested again; the return id is sent as idempotency key, so the buyer
+// is only paid once.
+func (app *application) updateReturnStatusHandler(status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ret, asSeller, ok := app.readReturn(w, r)
+		if !ok {
+			return
+		}
+
+		if !asSeller {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		var input struct {
+			Note string `json:"note"`
+		}
+
+		// The body is optional.
+		if r.ContentLength != 0 {
+			err := app.readJSON(w, r, &input)
+			if err != nil {
+				app.badRequestResponse(w, r, err)
+				return
+			}
+		}
+
+		refundPayment := status == model.ReturnStatusRefunded && ret.Purchase.PaymentID != 0
+
+		// A return which is already refunded may be refunded again, in case the gateway failed
+		// the first time.
+		retry := refundPayment && ret.Status == model.ReturnStatusRefunded
+
+		v := validator.New()
+
+		v.Check(len(input.Note) <= 500, "note", "must not be more than 500 bytes long")
+		v.Check(retry || ret.CanTransition(status), "status", "cannot change from "+ret.Status+" to "+status)
+
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		if !retry {
+			user := app.contextGetUser(r)
+
+			err := app.models.Returns.UpdateStatus(ret, status, user.ID, input.Note)
+			if err != nil {
+				switch {
+				case errors.Is(err, model.ErrInvalidTransition):
+					v.AddError("status", "cannot change from "+ret.Status+" to "+status)
+					app.failedValidationResponse(w, r, v.Errors)
+				case errors.Is(err, model.ErrEditConflict):
+					app.editConflictResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+		}
+
+		if refundPayment {
+			err := app.refundPayment(r, ret)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		// Reload the return so that the response contains the new audit entry.
+		ret, err := app.models.Returns.Get(ret.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.setAttachmentURLs(ret)
+
+		err = app.writeJSON(w, http.StatusOK, envelope{"return": ret}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+// refundPayment asks the payment gateway to refund the payment of the purchase of a return. A
+// payment which has already been refunded is left alone.
+func (app *application) refundPayment(r *http.Request, ret *model.Return) error {
+	payment, err := app.models.Payments.Get(ret.Purchase.PaymentID)
+	if err != nil {
+		return err
+	}
+
+	switch payment.Status {
+	case model.PaymentStatusRefunded:
+		return nil
+	case model.PaymentStatusCaptured:
+	default:
+		return fmt.Errorf("payment %d can't be refunded in status %s", payment.ID, payment.Status)
+	}
+
+	key := "return-" + strconv.FormatInt(ret.ID, 10)
+
+	return app.payments.Refund(r.Context(), payment.IntentID, ret.Purchase.Amount, key)
+}
+
+// readReturn loads the return addressed by the request URL. The buyer and the seller of the
+// purchased product may access it, as well as users with the "products:write" permission, who act
+// as the seller; everybody else gets a 404 response. asSeller reports whether the current user may
+// act as the seller. If anything goes wrong the error response is sent and ok is false.
+func (app *application) readReturn(w http.ResponseWriter, r *http.Request) (*model.Return, bool, bool) {
+	id, err := app.readIDParam(r, "return_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false, false
+	}
+
+	ret, err := app.models.Returns.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false, false
+	}
+
+	var asSeller bool
+
+	if ret.Purchase.SellerID != 0 {
+		seller, err := app.models.Sellers.Get(ret.Purchase.SellerID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return nil, false, false
+		}
+
+		asSeller, err = app.canManageSeller(r, seller, "products:write")
+	} else {
+		asSeller, err = app.userHasPermission(r, "products:write")
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false, false
+	}
+
+	user := app.contextGetUser(r)
+	if !asSeller && ret.UserID != user.ID {
+		app.notFoundResponse(w, r)
+		return nil, false, false
+	}
+
+	app.setAttachmentURLs(ret)
+
+	return ret, asSeller, true
+}
+
+func (app *application) setAttachmentURLs(ret *model.Return) {
+	for _, attachment := range ret.Attachments {
+		attachment.URL = app.storage.URL(attachment.StorageKey)
+	}
+}
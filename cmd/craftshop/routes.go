@@ -3,7 +3,10 @@ package main
 import (
 	"log"
 	"net/http"
+	"strings"
 
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/model"
+	"github.com/ainelnazaraly/CraftShop/pkg/storage"
 	"github.com/gorilla/mux"
 )
 
@@ -21,24 +24,73 @@ func (app *application) routes() http.Handler {
 
 	r.HandleFunc("/api/v1/healthcheck", app.healthcheckHandler).Methods("GET")
 
+	// Serve uploaded files when they are kept on the local filesystem under a path of this server.
+	// Directory listings are refused, so that stored files can only be found through their URLs.
+	if local, ok := app.storage.(*storage.Local); ok && strings.HasPrefix(local.BaseURL, "/") {
+		prefix := local.BaseURL + "/"
+		files := http.StripPrefix(prefix, http.FileServer(http.Dir(local.Dir)))
+		r.PathPrefix(prefix).HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(r.URL.Path, "/") {
+				app.notFoundResponse(w, r)
+				return
+			}
+			files.ServeHTTP(w, r)
+		}).Methods("GET")
+	}
+
 	//user
 
 	v1 := r.PathPrefix("/api/v1").Subrouter()
 
 	// Product Routes
-	v1.HandleFunc("/products", app.createProductHandler).Methods("POST")
+	v1.HandleFunc("/products", app.requireActivatedUser(app.createProductHandler)).Methods("POST")
 	v1.HandleFunc("/products/{product_id:[0-9]+}", app.getProductHandler).Methods("GET")
-	v1.HandleFunc("/products/{product_id:[0-9]+}", app.updateProductHandler).Methods("PUT")
-	v1.HandleFunc("/products/{product_id:[0-9]+}", app.requirePermissions("products:write", app.deleteProductHandler)).Methods("DELETE")
+	v1.HandleFunc("/products/{product_id:[0-9]+}", app.requireActivatedUser(app.updateProductHandler)).Methods("PUT")
+	v1.HandleFunc("/products/{product_id:[0-9]+}", app.requireActivatedUser(app.deleteProductHandler)).Methods("DELETE")
+	v1.HandleFunc("/products/{product_id:[0-9]+}/stock", app.requireActivatedUser(app.adjustProductStockHandler)).Methods("POST")
+	v1.HandleFunc("/products/{product_id:[0-9]+}/reservations", app.requireActivatedUser(app.createReservationHandler)).Methods("POST")
+	v1.HandleFunc("/reservations/{reservation_id:[0-9]+}", app.requireActivatedUser(app.deleteReservationHandler)).Methods("DELETE")
+	v1.HandleFunc("/variants/{sku}", app.getVariantHandler).Methods("GET")
+	v1.HandleFunc("/products/{product_id:[0-9]+}/shipping-quote", app.shippingQuoteHandler).Methods("GET")
+
+	// Product Image Routes
+	v1.HandleFunc("/products/{product_id:[0-9]+}/images", app.listProductImagesHandler).Methods("GET")
+	v1.HandleFunc("/products/{product_id:[0-9]+}/images", app.requireActivatedUser(app.uploadProductImageHandler)).Methods("POST")
+	v1.HandleFunc("/products/{product_id:[0-9]+}/images/order", app.requireActivatedUser(app.reorderProductImagesHandler)).Methods("PUT")
+	v1.HandleFunc("/products/{product_id:[0-9]+}/images/{image_id:[0-9]+}", app.requireActivatedUser(app.deleteProductImageHandler)).Methods("DELETE")
+
+	// Review Routes
+	v1.HandleFunc("/products/{product_id:[0-9]+}/reviews", app.listReviewsHandler).Methods("GET")
+	v1.HandleFunc("/products/{product_id:[0-9]+}/reviews", app.requireActivatedUser(app.createReviewHandler)).Methods("POST")
+	v1.HandleFunc("/products/{product_id:[0-9]+}/reviews/{review_id:[0-9]+}", app.requireActivatedUser(app.updateReviewHandler)).Methods("PUT")
+	v1.HandleFunc("/products/{product_id:[0-9]+}/reviews/{review_id:[0-9]+}", app.requireActivatedUser(app.deleteReviewHandler)).Methods("DELETE")
+
+	// Question Routes
+	v1.HandleFunc("/products/{product_id:[0-9]+}/questions", app.listQuestionsHandler).Methods("GET")
+	v1.HandleFunc("/products/{product_id:[0-9]+}/questions", app.requireActivatedUser(app.createQuestionHandler)).Methods("POST")
+	v1.HandleFunc("/products/{product_id:[0-9]+}/questions/{question_id:[0-9]+}/answer", app.requireActivatedUser(app.answerQuestionHandler)).Methods("PUT")
+	v1.HandleFunc("/products/{product_id:[0-9]+}/questions/{question_id:[0-9]+}/moderation", app.requirePermissions("questions:moderate", app.moderateQuestionHandler)).Methods("PUT")
 
-	// Create a new seller
-	v1.HandleFunc("/sellers", app.createSellerHandler).Methods("POST")
+	// Create a seller profile for the current user
+	v1.HandleFunc("/sellers", app.requireActivatedUser(app.createSellerHandler)).Methods("POST")
+	// Sign a seller in and issue an authentication token for their user account
+	v1.HandleFunc("/sellers/login", app.createSellerAuthenticationTokenHandler).Methods("POST")
 	// Retrieve a seller by sellerName
 	v1.HandleFunc("/sellers/{seller_id:[0-9]+}",  app.getSellerHandler).Methods("GET")
 	// Update a seller's information by sellerName
-	v1.HandleFunc("/sellers/{seller_id:[0-9]+}",  app.updateSellerHandler).Methods("PUT")
+	v1.HandleFunc("/sellers/{seller_id:[0-9]+}",  app.requireActivatedUser(app.updateSellerHandler)).Methods("PUT")
 	// Delete a seller by sellerName
-	v1.HandleFunc("/sellers/{seller_id:[0-9]+}", app.requirePermissions("sellers:write", app.deleteSellerHandler)).Methods("DELETE")
+	v1.HandleFunc("/sellers/{seller_id:[0-9]+}", app.requireActivatedUser(app.deleteSellerHandler)).Methods("DELETE")
+	// Show what the marketplace owes a seller and the changes of that balance over a period
+	v1.HandleFunc("/sellers/{seller_id:[0-9]+}/balance", app.requireActivatedUser(app.sellerBalanceHandler)).Methods("GET")
+	v1.HandleFunc("/sellers/{seller_id:[0-9]+}/statement", app.requireActivatedUser(app.sellerStatementHandler)).Methods("GET")
+
+	// Shipping Profile Routes
+	v1.HandleFunc("/sellers/{seller_id:[0-9]+}/shipping-profiles", app.listShippingProfilesHandler).Methods("GET")
+	v1.HandleFunc("/sellers/{seller_id:[0-9]+}/shipping-profiles", app.requireActivatedUser(app.createShippingProfileHandler)).Methods("POST")
+	v1.HandleFunc("/shipping-profiles/{profile_id:[0-9]+}", app.showShippingProfileHandler).Methods("GET")
+	v1.HandleFunc("/shipping-profiles/{profile_id:[0-9]+}", app.requireActivatedUser(app.updateShippingProfileHandler)).Methods("PUT")
+	v1.HandleFunc("/shipping-profiles/{profile_id:[0-9]+}", app.requireActivatedUser(app.deleteShippingProfileHandler)).Methods("DELETE")
 
 	// Retrieve a list of sellers
 	v1.HandleFunc("/products", app.requireActivatedUser(app.listProductsHandler)).Methods("GET")
@@ -48,5 +100,80 @@ func (app *application) routes() http.Handler {
 	v1.HandleFunc("/users", app.registerUserHandler).Methods("POST")
 	v1.HandleFunc("/users/activated", app.activateUserHandler).Methods("PUT")
 	v1.HandleFunc("/users/login", app.createAuthenticationTokenHandler).Methods("POST")
+	v1.HandleFunc("/users/password", app.updateUserPasswordHandler).Methods("PUT")
+	v1.HandleFunc("/tokens/password-reset", app.createPasswordResetTokenHandler).Methods("POST")
+	v1.HandleFunc("/tokens/refresh", app.refreshAuthenticationTokenHandler).Methods("POST")
+	v1.HandleFunc("/tokens/authentication", app.requireAuthenticatedUser(app.deleteAuthenticationTokenHandler)).Methods("DELETE")
+	v1.HandleFunc("/users/me/sessions", app.requireAuthenticatedUser(app.listSessionsHandler)).Methods("GET")
+	v1.HandleFunc("/users/me/sessions", app.requireAuthenticatedUser(app.deleteSessionsHandler)).Methods("DELETE")
+
+	// Cart Routes
+	v1.HandleFunc("/cart", app.requireActivatedUser(app.showCartHandler)).Methods("GET")
+	v1.HandleFunc("/cart", app.requireActivatedUser(app.clearCartHandler)).Methods("DELETE")
+	v1.HandleFunc("/cart/items", app.requireActivatedUser(app.addCartItemHandler)).Methods("POST")
+	v1.HandleFunc("/cart/items/{product_id:[0-9]+}", app.requireActivatedUser(app.updateCartItemHandler)).Methods("PUT")
+	v1.HandleFunc("/cart/items/{product_id:[0-9]+}", app.requireActivatedUser(app.removeCartItemHandler)).Methods("DELETE")
+	v1.HandleFunc("/cart/merge", app.requireActivatedUser(app.mergeCartHandler)).Methods("POST")
+
+	// Wishlist Routes
+	v1.HandleFunc("/me/wishlists", app.requireActivatedUser(app.listWishlistsHandler)).Methods("GET")
+	v1.HandleFunc("/me/wishlists", app.requireActivatedUser(app.createWishlistHandler)).Methods("POST")
+	v1.HandleFunc("/me/wishlists/{wishlist_id:[0-9]+}", app.requireActivatedUser(app.showWishlistHandler)).Methods("GET")
+	v1.HandleFunc("/me/wishlists/{wishlist_id:[0-9]+}", app.requireActivatedUser(app.updateWishlistHandler)).Methods("PUT")
+	v1.HandleFunc("/me/wishlists/{wishlist_id:[0-9]+}", app.requireActivatedUser(app.deleteWishlistHandler)).Methods("DELETE")
+	v1.HandleFunc("/me/wishlists/{wishlist_id:[0-9]+}/items", app.requireActivatedUser(app.addWishlistItemHandler)).Methods("POST")
+	v1.HandleFunc("/me/wishlists/{wishlist_id:[0-9]+}/items/{product_id:[0-9]+}", app.requireActivatedUser(app.removeWishlistItemHandler)).Methods("DELETE")
+	v1.HandleFunc("/me/wishlists/{wishlist_id:[0-9]+}/share", app.requireActivatedUser(app.shareWishlistHandler)).Methods("PUT")
+	v1.HandleFunc("/me/wishlists/{wishlist_id:[0-9]+}/share", app.requireActivatedUser(app.unshareWishlistHandler)).Methods("DELETE")
+	v1.HandleFunc("/wishlists/shared/{token}", app.showSharedWishlistHandler).Methods("GET")
+	v1.HandleFunc("/me/favourite-sellers", app.requireActivatedUser(app.listFavouriteSellersHandler)).Methods("GET")
+	v1.HandleFunc("/me/favourite-sellers/{seller_id:[0-9]+}", app.requireActivatedUser(app.addFavouriteSellerHandler)).Methods("PUT")
+	v1.HandleFunc("/me/favourite-sellers/{seller_id:[0-9]+}", app.requireActivatedUser(app.removeFavouriteSellerHandler)).Methods("DELETE")
+
+	// Order Routes
+	v1.HandleFunc("/orders", app.requireActivatedUser(app.createOrderHandler)).Methods("POST")
+	v1.HandleFunc("/orders", app.requireActivatedUser(app.listOrdersHandler)).Methods("GET")
+	v1.HandleFunc("/orders/{order_id:[0-9]+}", app.requireActivatedUser(app.showOrderHandler)).Methods("GET")
+	v1.HandleFunc("/orders/{order_id:[0-9]+}/status", app.requireActivatedUser(app.updateOrderStatusHandler)).Methods("PUT")
+	v1.HandleFunc("/sellers/{seller_id:[0-9]+}/orders", app.requireActivatedUser(app.listSellerOrdersHandler)).Methods("GET")
+
+	// Coupon Routes
+	v1.HandleFunc("/coupons", app.requirePermissions("coupons:write", app.createCouponHandler)).Methods("POST")
+	v1.HandleFunc("/coupons", app.requirePermissions("coupons:write", app.listCouponsHandler)).Methods("GET")
+	v1.HandleFunc("/coupons/{coupon_id:[0-9]+}", app.requirePermissions("coupons:write", app.showCouponHandler)).Methods("GET")
+	v1.HandleFunc("/coupons/{coupon_id:[0-9]+}", app.requirePermissions("coupons:write", app.updateCouponHandler)).Methods("PUT")
+	v1.HandleFunc("/coupons/{coupon_id:[0-9]+}", app.requirePermissions("coupons:write", app.deleteCouponHandler)).Methods("DELETE")
+	v1.HandleFunc("/coupons/{code}/apply", app.requireActivatedUser(app.applyCouponHandler)).Methods("POST")
+
+	// Tax Routes
+	v1.HandleFunc("/quotes", app.createQuoteHandler).Methods("POST")
+	v1.HandleFunc("/tax-rules", app.requirePermissions("tax:write", app.createTaxRuleHandler)).Methods("POST")
+	v1.HandleFunc("/tax-rules", app.requirePermissions("tax:write", app.listTaxRulesHandler)).Methods("GET")
+	v1.HandleFunc("/tax-rules/{rule_id:[0-9]+}", app.requirePermissions("tax:write", app.showTaxRuleHandler)).Methods("GET")
+	v1.HandleFunc("/tax-rules/{rule_id:[0-9]+}", app.requirePermissions("tax:write", app.updateTaxRuleHandler)).Methods("PUT")
+	v1.HandleFunc("/tax-rules/{rule_id:[0-9]+}", app.requirePermissions("tax:write", app.deleteTaxRuleHandler)).Methods("DELETE")
+
+	// Currency Routes
+	v1.HandleFunc("/currencies", app.listCurrenciesHandler).Methods("GET")
+	v1.HandleFunc("/currencies", app.requirePermissions("currencies:write", app.createCurrencyHandler)).Methods("POST")
+	v1.HandleFunc("/currencies/{code:[A-Za-z]{3}}", app.showCurrencyHandler).Methods("GET")
+	v1.HandleFunc("/currencies/{code:[A-Za-z]{3}}/rates", app.listExchangeRatesHandler).Methods("GET")
+	v1.HandleFunc("/currencies/{code:[A-Za-z]{3}}/rates", app.requirePermissions("currencies:write", app.createExchangeRateHandler)).Methods("POST")
+
+	// Payment Routes
+	v1.HandleFunc("/products/{product_id:[0-9]+}/buy", app.requireActivatedUser(app.buyProductHandler)).Methods("POST")
+	v1.HandleFunc("/payments/{payment_id:[0-9]+}", app.requireActivatedUser(app.showPaymentHandler)).Methods("GET")
+	v1.HandleFunc("/payments/webhook", app.paymentWebhookHandler).Methods("POST")
+
+	// Return Routes
+	v1.HandleFunc("/purchases", app.requireActivatedUser(app.listPurchasesHandler)).Methods("GET")
+	v1.HandleFunc("/purchases/{purchase_id:[0-9]+}/returns", app.requireActivatedUser(app.createReturnHandler)).Methods("POST")
+	v1.HandleFunc("/returns", app.requireActivatedUser(app.listReturnsHandler)).Methods("GET")
+	v1.HandleFunc("/returns/{return_id:[0-9]+}", app.requireActivatedUser(app.showReturnHandler)).Methods("GET")
+	v1.HandleFunc("/returns/{return_id:[0-9]+}/attachments", app.requireActivatedUser(app.uploadReturnAttachmentHandler)).Methods("POST")
+	v1.HandleFunc("/returns/{return_id:[0-9]+}/approve", app.requireActivatedUser(app.updateReturnStatusHandler(model.ReturnStatusApproved))).Methods("POST")
+	v1.HandleFunc("/returns/{return_id:[0-9]+}/reject", app.requireActivatedUser(app.updateReturnStatusHandler(model.ReturnStatusRejected))).Methods("POST")
+	v1.HandleFunc("/returns/{return_id:[0-9]+}/receive", app.requireActivatedUser(app.updateReturnStatusHandler(model.ReturnStatusReceived))).Methods("POST")
+	v1.HandleFunc("/returns/{return_id:[0-9]+}/refund", app.requireActivatedUser(app.updateReturnStatusHandler(model.ReturnStatusRefunded))).Methods("POST")
 	return app.authenticate(r)
 }
@@ -2,8 +2,10 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/model"
 	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/validator"
@@ -27,28 +29,62 @@ func (app *application) respondWithJSON(w http.ResponseWriter, code int, payload
 	w.Write(response)
 }
 
+// createSellerHandler creates the seller profile of the current user. Every user can have at most
+// one seller profile.
 func (app *application) createSellerHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		SellerName string `json:"seller_name"`
-		Email      string `json:"email"`
-		Password   string `json:"password"`
-		Location   string `json:"location"`
+		SellerName string  `json:"seller_name"`
+		Email      string  `json:"email"`
+		Password   *string `json:"password"`
+		Location   string  `json:"location"`
 	}
 	err := app.readJSON(w, r, &input)
 	if err != nil {
 		app.respondWithError(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
+
+	user := app.contextGetUser(r)
+
+	// The contact address of the shop defaults to the address of the user account.
+	if input.Email == "" {
+		input.Email = user.Email
+	}
+
 	seller := &model.Seller{
+		UserID:     user.ID,
 		SellerName: input.SellerName,
 		Email:      input.Email,
-		Password:   input.Password,
 		Location:   input.Location,
 	}
 
+	if input.Password != nil {
+		err = seller.Password.Set(*input.Password)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	v := validator.New()
+
+	if model.ValidateSeller(v, seller); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	err = app.models.Sellers.Insert(seller)
 	if err != nil {
-		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server error")
+		switch {
+		case errors.Is(err, model.ErrDuplicateSeller):
+			v.AddError("user_id", "you already have a seller profile")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, model.ErrDuplicateEmail):
+			v.AddError("email", "a seller with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server error")
+		}
 		return
 	}
 	app.respondWithJSON(w, http.StatusCreated, seller)
@@ -66,7 +102,12 @@ func (app *application) getSellerHandler(w http.ResponseWriter, r *http.Request)
 
 	seller, err := app.models.Sellers.Get(id)
 	if err != nil {
-		app.respondWithError(w, http.StatusNotFound, "404 not found")
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.respondWithError(w, http.StatusNotFound, "404 not found")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -74,18 +115,8 @@ func (app *application) getSellerHandler(w http.ResponseWriter, r *http.Request)
 }
 
 func (app *application) updateSellerHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	param := vars["seller_id"]
-
-	id, err := strconv.Atoi(param)
-	if err != nil || id < 1 {
-		app.respondWithError(w, http.StatusBadRequest, "Invalid seller ID")
-		return
-	}
-
-	seller, err := app.models.Sellers.Get(id)
-	if err != nil {
-		app.respondWithError(w, http.StatusNotFound, "404 not found")
+	seller, ok := app.readOwnSeller(w, r)
+	if !ok {
 		return
 	}
 
@@ -96,7 +127,7 @@ func (app *application) updateSellerHandler(w http.ResponseWriter, r *http.Reque
 		Location   *string `json:"location"`
 	}
 
-	err = app.readJSON(w, r, &input)
+	err := app.readJSON(w, r, &input)
 	if err != nil {
 		app.respondWithError(w, http.StatusBadRequest, "Invalid request payload")
 		return
@@ -111,16 +142,35 @@ func (app *application) updateSellerHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	if input.Password != nil {
-		seller.Password = *input.Password
+		err = seller.Password.Set(*input.Password)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
 	}
 
 	if input.Location != nil {
 		seller.Location = *input.Location
 	}
 
+	v := validator.New()
+
+	if model.ValidateSeller(v, seller); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	err = app.models.Sellers.Update(seller)
 	if err != nil {
-		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrDuplicateEmail):
+			v.AddError("email", "a seller with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		}
 		return
 	}
 
@@ -128,16 +178,12 @@ func (app *application) updateSellerHandler(w http.ResponseWriter, r *http.Reque
 }
 
 func (app *application) deleteSellerHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	param := vars["seller_id"]
-
-	id, err := strconv.Atoi(param)
-	if err != nil || id < 1 {
-		app.respondWithError(w, http.StatusBadRequest, "Invalid seller ID")
+	seller, ok := app.readOwnSeller(w, r)
+	if !ok {
 		return
 	}
 
-	err = app.models.Sellers.Delete(id)
+	err := app.models.Sellers.Delete(seller.SellerID)
 	if err != nil {
 		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
 		return
@@ -146,6 +192,97 @@ func (app *application) deleteSellerHandler(w http.ResponseWriter, r *http.Reque
 	app.respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
 }
 
+// sellerBalanceHandler shows what the marketplace currently owes a seller and what has been paid
+// out to them.
+func (app *application) sellerBalanceHandler(w http.ResponseWriter, r *http.Request) {
+	seller, ok := app.readOwnSeller(w, r)
+	if !ok {
+		return
+	}
+
+	balance, err := app.models.Ledger.SellerBalance(seller.SellerID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"balance": balance}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// sellerStatementHandler lists the changes of the balance of a seller between the from and to
+// dates, both inclusive. It defaults to the last 30 days.
+func (app *application) sellerStatementHandler(w http.ResponseWriter, r *http.Request) {
+	seller, ok := app.readOwnSeller(w, r)
+	if !ok {
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	from := app.readDate(qs, "from", today.AddDate(0, 0, -30), v)
+	to := app.readDate(qs, "to", today, v)
+
+	v.Check(!from.After(to), "from", "must not be after to")
+	v.Check(to.Sub(from) <= 366*24*time.Hour, "to", "must not be more than 366 days after from")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	statement, err := app.models.Ledger.SellerStatement(seller.SellerID, from, to.Add(24*time.Hour))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"statement": statement}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readOwnSeller loads the seller addressed by the request URL and checks that it is the profile of
+// the current user, or that the user has the "sellers:write" permission. If anything goes wrong
+// the error response is sent and ok is false.
+func (app *application) readOwnSeller(w http.ResponseWriter, r *http.Request) (*model.Seller, bool) {
+	id, err := app.readIDParam(r, "seller_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	seller, err := app.models.Sellers.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	ok, err := app.canManageSeller(r, seller, "sellers:write")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	if !ok {
+		app.notPermittedResponse(w, r)
+		return nil, false
+	}
+
+	return seller, true
+}
+
 func (app *application) listSellersHandler(w http.ResponseWriter, r *http.Request) {
     var input struct {
         Location string
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/model"
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/validator"
+)
+
+// shippingProfileInput is the shipping profile sent by the client when creating or updating a
+// profile. Zones replace the zones of the profile as a whole.
+type shippingProfileInput struct {
+	Name              *string               `json:"name"`
+	ProcessingDaysMin *int                  `json:"processing_days_min"`
+	ProcessingDaysMax *int                  `json:"processing_days_max"`
+	Zones             []*model.ShippingZone `json:"zones"`
+}
+
+// apply copies the fields present in the input to the profile.
+func (input *shippingProfileInput) apply(profile *model.ShippingProfile) {
+	if input.Name != nil {
+		profile.Name = *input.Name
+	}
+
+	if input.ProcessingDaysMin != nil {
+		profile.ProcessingDaysMin = *input.ProcessingDaysMin
+	}
+
+	if input.ProcessingDaysMax != nil {
+		profile.ProcessingDaysMax = *input.ProcessingDaysMax
+	}
+
+	if input.Zones != nil {
+		profile.Zones = input.Zones
+	}
+}
+
+func (app *application) createShippingProfileHandler(w http.ResponseWriter, r *http.Request) {
+	seller, ok := app.readOwnSeller(w, r)
+	if !ok {
+		return
+	}
+
+	var input shippingProfileInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	profile := &model.ShippingProfile{
+		SellerID:          seller.SellerID,
+		ProcessingDaysMin: 1,
+		ProcessingDaysMax: 3,
+	}
+
+	input.apply(profile)
+
+	v := validator.New()
+
+	if model.ValidateShippingProfile(v, profile); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.ShippingProfiles.Insert(profile)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"shipping_profile": profile}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listShippingProfilesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r, "seller_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Sellers.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	profiles, err := app.models.ShippingProfiles.GetAllForSeller(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"shipping_profiles": profiles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showShippingProfileHandler(w http.ResponseWriter, r *http.Request) {
+	profile, ok := app.readShippingProfile(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"shipping_profile": profile}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateShippingProfileHandler(w http.ResponseWriter, r *http.Request) {
+	profile, ok := app.readOwnShippingProfile(w, r)
+	if !ok {
+		return
+	}
+
+	var input shippingProfileInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	input.apply(profile)
+
+	v := validator.New()
+
+	if model.ValidateShippingProfile(v, profile); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.ShippingProfiles.Update(profile)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"shipping_profile": profile}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteShippingProfileHandler(w http.ResponseWriter, r *http.Request) {
+	profile, ok := app.readOwnShippingProfile(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.ShippingProfiles.Delete(profile.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "shipping profile successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// shippingQuoteHandler prices shipping a product to the destination given by the country and
+// region query parameters. A free shipping coupon given by the coupon query parameter makes the
+// shipping free if the current user may use it for the product.
+func (app *application) shippingQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r, "product_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	country := strings.ToUpper(app.readString(qs, "country", ""))
+	region := strings.ToUpper(app.readString(qs, "region", ""))
+	quantity := app.readInt(qs, "quantity", 1, v)
+	couponCode := app.readString(qs, "coupon", "")
+
+	v.Check(country != "", "country", "must be provided")
+	v.Check(country == "" || validator.Matches(country, model.CountryRX), "country", "must be an ISO 3166-1 alpha-2 country code")
+	v.Check(len(region) <= 6, "region", "must be an ISO 3166-2 region code")
+	v.Check(quantity > 0, "quantity", "must be greater than zero")
+	v.Check(quantity <= 1000, "quantity", "must not be more than 1000")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	product, err := app.models.Products.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if product.ShippingProfileID == 0 {
+		v.AddError("product_id", "the seller hasn't set up shipping for this product")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	profile, err := app.models.ShippingProfiles.Get(product.ShippingProfileID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	quote, err := profile.Quote(product, quantity, country, region)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrNoShippingZone):
+			v.AddError("country", "the product is not shipped to this destination")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if couponCode != "" {
+		items := []*model.CouponItem{{ProductID: product.ID, Quantity: quantity}}
+
+		couponQuote, err := app.models.Coupons.Quote(couponCode, app.contextGetUser(r).ID, items)
+		if err != nil {
+			switch {
+			case errors.Is(err, model.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			case errors.Is(err, model.ErrCouponNotApplicable):
+				v.AddError("coupon", err.Error())
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if !couponQuote.FreeShipping {
+			v.AddError("coupon", "it is not a free shipping coupon")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		quote.Cost = 0
+		quote.FreeShipping = true
+		quote.Coupon = couponQuote.Code
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"shipping_quote": quote}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readShippingProfile reads the shipping profile of the profile_id route parameter, sending a 404
+// Not Found response if it doesn't exist.
+func (app *application) readShippingProfile(w http.ResponseWriter, r *http.Request) (*model.ShippingProfile, bool) {
+	id, err := app.readIDParam(r, "profile_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	profile, err := app.models.ShippingProfiles.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return profile, true
+}
+
+// readOwnShippingProfile reads the shipping profile of the profile_id route parameter and checks
+// that the current user may manage the seller it belongs to.
+func (app *application) readOwnShippingProfile(w http.ResponseWriter, r *http.Request) (*model.ShippingProfile, bool) {
+	profile, ok := app.readShippingProfile(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	seller, err := app.models.Sellers.Get(profile.SellerID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	ok, err = app.canManageSeller(r, seller, "sellers:write")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	if !ok {
+		app.notPermittedResponse(w, r)
+		return nil, false
+	}
+
+	return profile, true
+}
+
+// validateProductShippingProfile checks that the shipping profile of a product belongs to the
+// seller of the product.
+func (app *application) validateProductShippingProfile(v *validator.Validator, product *model.Product) error {
+	if product.ShippingProfileID == 0 {
+		return nil
+	}
+
+	profile, err := app.models.ShippingProfiles.Get(product.ShippingProfileID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			v.AddError("shipping_profile_id", "shipping profile does not exist")
+			return nil
+		default:
+			return err
+		}
+	}
+
+	v.Check(profile.SellerID == product.SellerID, "shipping_profile_id", "must be a shipping profile of the seller of the product")
+
+	return nil
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/model"
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/validator"
+)
+
+func (app *application) createTaxRuleHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name      string `json:"name"`
+		Country   string `json:"country"`
+		Region    string `json:"region"`
+		Category  string `json:"category"`
+		RateBPS   int    `json:"rate_bps"`
+		Inclusive bool   `json:"inclusive"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	country := strings.ToUpper(input.Country)
+
+	rule := &model.TaxRule{
+		Name:      input.Name,
+		Country:   country,
+		Region:    model.RegionCode(country, input.Region),
+		Category:  input.Category,
+		RateBPS:   input.RateBPS,
+		Inclusive: input.Inclusive,
+	}
+
+	v := validator.New()
+
+	if model.ValidateTaxRule(v, rule); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.TaxRules.Insert(rule)
+	if err != nil {
+		app.taxRuleWriteErrorResponse(w, r, v, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"tax_rule": rule}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listTaxRulesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Country string
+		model.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Country = strings.ToUpper(app.readString(qs, "country", ""))
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "country")
+
+	input.Filters.SortSafeList = []string{"id", "country", "rate_bps", "created_at", "-id", "-country", "-rate_bps", "-created_at"}
+
+	if model.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	rules, metadata, err := app.models.TaxRules.GetAll(input.Country, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"tax_rules": rules, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showTaxRuleHandler(w http.ResponseWriter, r *http.Request) {
+	rule, ok := app.readTaxRule(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"tax_rule": rule}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateTaxRuleHandler(w http.ResponseWriter, r *http.Request) {
+	rule, ok := app.readTaxRule(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name      *string `json:"name"`
+		Country   *string `json:"country"`
+		Region    *string `json:"region"`
+		Category  *string `json:"category"`
+		RateBPS   *int    `json:"rate_bps"`
+		Inclusive *bool   `json:"inclusive"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		rule.Name = *input.Name
+	}
+	if input.Country != nil {
+		rule.Country = strings.ToUpper(*input.Country)
+	}
+	if input.Region != nil {
+		rule.Region = model.RegionCode(rule.Country, *input.Region)
+	}
+	if input.Category != nil {
+		rule.Category = *input.Category
+	}
+	if input.RateBPS != nil {
+		rule.RateBPS = *input.RateBPS
+	}
+	if input.Inclusive != nil {
+		rule.Inclusive = *input.Inclusive
+	}
+
+	v := validator.New()
+
+	if model.ValidateTaxRule(v, rule); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.TaxRules.Update(rule)
+	if err != nil {
+		app.taxRuleWriteErrorResponse(w, r, v, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"tax_rule": rule}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteTaxRuleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r, "rule_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.TaxRules.Delete(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "tax rule successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createQuoteHandler prices a list of products for a destination with the tax of every line.
+func (app *application) createQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Country string `json:"country"`
+		Region  string `json:"region"`
+		Items   []struct {
+			ProductID int `json:"product_id"`
+			Quantity  int `json:"quantity"`
+		} `json:"items"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	country := strings.ToUpper(input.Country)
+	region := model.RegionCode(country, input.Region)
+
+	v := validator.New()
+
+	v.Check(validator.Matches(country, model.CountryRX), "country", "must be an ISO 3166-1 alpha-2 country code")
+	v.Check(region == "" || validator.Matches(region, model.RegionRX), "region", "must be an ISO 3166-2 region code")
+	v.Check(len(input.Items) > 0, "items", "must contain at least one item")
+	v.Check(len(input.Items) <= 100, "items", "must not contain more than 100 items")
+
+	lines := make([]*model.QuoteLine, len(input.Items))
+	seen := make(map[int]bool, len(input.Items))
+
+	for i, item := range input.Items {
+		if item.Quantity == 0 {
+			item.Quantity = 1
+		}
+
+		v.Check(item.ProductID > 0, fmt.Sprintf("items[%d].product_id", i), "must be provided")
+		v.Check(item.Quantity > 0, fmt.Sprintf("items[%d].quantity", i), "must be greater than zero")
+		v.Check(item.Quantity <= 1000, fmt.Sprintf("items[%d].quantity", i), "must not be more than 1000")
+		v.Check(!seen[item.ProductID], fmt.Sprintf("items[%d].product_id", i), "must not be duplicated")
+		seen[item.ProductID] = true
+
+		lines[i] = &model.QuoteLine{ProductID: item.ProductID, Quantity: item.Quantity}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	quote, err := app.models.TaxRules.Quote(country, region, lines)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			v.AddError("items", "one or more products do not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"quote": quote}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readTaxRule reads the tax rule of the rule_id route parameter, sending a 404 Not Found response
+// if it doesn't exist.
+func (app *application) readTaxRule(w http.ResponseWriter, r *http.Request) (*model.TaxRule, bool) {
+	id, err := app.readIDParam(r, "rule_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	rule, err := app.models.TaxRules.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return rule, true
+}
+
+func (app *application) taxRuleWriteErrorResponse(w http.ResponseWriter, r *http.Request, v *validator.Validator, err error) {
+	switch {
+	case errors.Is(err, model.ErrDuplicateTaxRule):
+		v.AddError("country", "a tax rule for this destination and category already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, model.ErrEditConflict):
+		app.editConflictResponse(w, r)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
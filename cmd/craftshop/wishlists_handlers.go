@@ -0,0 +1,408 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/model"
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/validator"
+	"github.com/gorilla/mux"
+)
+
+func (app *application) listWishlistsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	wishlists, err := app.models.Wishlists.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"wishlists": wishlists}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createWishlistHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string `json:"name"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	wishlist := &model.Wishlist{
+		UserID: app.contextGetUser(r).ID,
+		Name:   input.Name,
+	}
+
+	v := validator.New()
+
+	if model.ValidateWishlist(v, wishlist); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Wishlists.Insert(wishlist)
+	if err != nil {
+		app.wishlistWriteErrorResponse(w, r, v, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"wishlist": wishlist}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showWishlistHandler(w http.ResponseWriter, r *http.Request) {
+	wishlist, ok := app.readOwnWishlist(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"wishlist": wishlist}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateWishlistHandler(w http.ResponseWriter, r *http.Request) {
+	wishlist, ok := app.readOwnWishlist(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name *string `json:"name"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		wishlist.Name = *input.Name
+	}
+
+	v := validator.New()
+
+	if model.ValidateWishlist(v, wishlist); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Wishlists.Update(wishlist)
+	if err != nil {
+		app.wishlistWriteErrorResponse(w, r, v, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"wishlist": wishlist}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteWishlistHandler(w http.ResponseWriter, r *http.Request) {
+	wishlist, ok := app.readOwnWishlist(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Wishlists.Delete(wishlist.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "wishlist successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// addWishlistItemHandler saves a product in a wishlist at its current price.
+func (app *application) addWishlistItemHandler(w http.ResponseWriter, r *http.Request) {
+	wishlist, ok := app.readOwnWishlist(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		ProductID int `json:"product_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.ProductID > 0, "product_id", "must be provided")
+	v.Check(len(wishlist.Items) < 500, "product_id", "the wishlist must not contain more than 500 products")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Wishlists.AddItem(wishlist.ID, input.ProductID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			v.AddError("product_id", "product does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeWishlist(w, r, wishlist.ID)
+}
+
+func (app *application) removeWishlistItemHandler(w http.ResponseWriter, r *http.Request) {
+	wishlist, ok := app.readOwnWishlist(w, r)
+	if !ok {
+		return
+	}
+
+	productID, err := app.readIDParam(r, "product_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Wishlists.RemoveItem(wishlist.ID, productID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeWishlist(w, r, wishlist.ID)
+}
+
+// shareWishlistHandler creates a share token for a wishlist, which makes it readable by anyone
+// who has the token. A wishlist which is already shared keeps its token.
+func (app *application) shareWishlistHandler(w http.ResponseWriter, r *http.Request) {
+	wishlist, ok := app.readOwnWishlist(w, r)
+	if !ok {
+		return
+	}
+
+	if wishlist.ShareToken == "" {
+		token, err := model.NewShareToken()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		wishlist.ShareToken = token
+
+		err = app.models.Wishlists.Update(wishlist)
+		if err != nil {
+			app.wishlistWriteErrorResponse(w, r, validator.New(), err)
+			return
+		}
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"wishlist": wishlist}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// unshareWishlistHandler revokes the share token of a wishlist. Links with the old token stop
+// working, and sharing the wishlist again creates a new token.
+func (app *application) unshareWishlistHandler(w http.ResponseWriter, r *http.Request) {
+	wishlist, ok := app.readOwnWishlist(w, r)
+	if !ok {
+		return
+	}
+
+	if wishlist.ShareToken != "" {
+		wishlist.ShareToken = ""
+
+		err := app.models.Wishlists.Update(wishlist)
+		if err != nil {
+			app.wishlistWriteErrorResponse(w, r, validator.New(), err)
+			return
+		}
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"wishlist": wishlist}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showSharedWishlistHandler returns a wishlist by its share token. It doesn't require
+// authentication, and the share token itself is left out of the response.
+func (app *application) showSharedWishlistHandler(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+
+	v := validator.New()
+
+	if model.ValidateTokenPlaintext(v, token); !v.Valid() {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	wishlist, err := app.models.Wishlists.GetByShareToken(token)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	wishlist.ShareToken = ""
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"wishlist": wishlist}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listFavouriteSellersHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	sellers, err := app.models.Wishlists.GetFavouriteSellers(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"sellers": sellers}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) addFavouriteSellerHandler(w http.ResponseWriter, r *http.Request) {
+	sellerID, err := app.readIDParam(r, "seller_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.Wishlists.AddFavouriteSeller(user.ID, sellerID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "seller saved"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) removeFavouriteSellerHandler(w http.ResponseWriter, r *http.Request) {
+	sellerID, err := app.readIDParam(r, "seller_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.Wishlists.RemoveFavouriteSeller(user.ID, sellerID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "seller removed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readOwnWishlist reads the wishlist of the wishlist_id route parameter. Wishlists of other users
+// are reported as not found, so their IDs can't be probed.
+func (app *application) readOwnWishlist(w http.ResponseWriter, r *http.Request) (*model.Wishlist, bool) {
+	id, err := app.readIDParam(r, "wishlist_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	wishlist, err := app.models.Wishlists.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	if wishlist.UserID != app.contextGetUser(r).ID {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	return wishlist, true
+}
+
+// writeWishlist reads a wishlist again after its items changed and sends it in the response.
+func (app *application) writeWishlist(w http.ResponseWriter, r *http.Request, id int64) {
+	wishlist, err := app.models.Wishlists.Get(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"wishlist": wishlist}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) wishlistWriteErrorResponse(w http.ResponseWriter, r *http.Request, v *validator.Validator, err error) {
+	switch {
+	case errors.Is(err, model.ErrDuplicateWishlist):
+		v.AddError("name", "a wishlist with this name already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, model.ErrEditConflict):
+		app.editConflictResponse(w, r)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
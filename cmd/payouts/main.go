@@ -0,0 +1,56 @@
+// Command payouts pays out what the marketplace owes its sellers. Every run creates a payout batch
+// which moves the available balance of every seller owed at least -min-amount cents to their paid
+// out account in the ledger. It is meant to be run periodically, e.g. from cron.
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"os"
+	"strconv"
+
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/model"
+	"github.com/ainelnazaraly/CraftShop/pkg/jsonlog"
+	_ "github.com/lib/pq"
+)
+
+func main() {
+	var dsn string
+	var minAmount int64
+
+	flag.StringVar(&dsn, "db-dsn", os.Getenv("CRAFTSHOP_DB_DSN"), "PostgreSQL DSN")
+	flag.Int64Var(&minAmount, "min-amount", 1000, "Minimum balance in cents for a seller to be paid out")
+	flag.Parse()
+
+	logger := jsonlog.NewLogger(os.Stdout, jsonlog.LevelInfo)
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	defer db.Close()
+
+	err = db.Ping()
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	batch, err := model.NewModel(db).Ledger.PayOut(minAmount)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	for _, payout := range batch.Payouts {
+		logger.PrintInfo("seller paid out", map[string]string{
+			"batch_id":  strconv.FormatInt(batch.ID, 10),
+			"seller_id": strconv.Itoa(payout.SellerID),
+			"amount":    strconv.FormatInt(payout.Amount, 10),
+		})
+	}
+
+	logger.PrintInfo("payout batch completed", map[string]string{
+		"batch_id": strconv.FormatInt(batch.ID, 10),
+		"sellers":  strconv.Itoa(len(batch.Payouts)),
+		"total":    strconv.FormatInt(batch.Total, 10),
+	})
+}
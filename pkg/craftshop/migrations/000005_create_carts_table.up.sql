@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS carts (
+    id bigserial PRIMARY KEY,
+    user_id bigint UNIQUE NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS cart_items (
+    cart_id bigint NOT NULL REFERENCES carts ON DELETE CASCADE,
+    product_id bigint NOT NULL REFERENCES products(product_id) ON DELETE CASCADE,
+    quantity integer NOT NULL CHECK (quantity > 0),
+    added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (cart_id, product_id)
+);
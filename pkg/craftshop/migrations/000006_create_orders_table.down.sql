@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE code = 'orders:write';
+DROP TABLE IF EXISTS order_status_history;
+DROP TABLE IF EXISTS order_items;
+DROP TABLE IF EXISTS orders;
@@ -0,0 +1,16 @@
+ALTER TABLE products ADD COLUMN IF NOT EXISTS stock integer NOT NULL DEFAULT 0;
+
+ALTER TABLE products ADD CONSTRAINT products_stock_check CHECK (stock >= 0);
+
+-- A reservation holds back part of a product's stock for a single user until it expires or the
+-- user places an order for the product. Expired rows are ignored and cleaned up lazily.
+CREATE TABLE IF NOT EXISTS stock_reservations (
+    id bigserial PRIMARY KEY,
+    product_id bigint NOT NULL REFERENCES products(product_id) ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    quantity integer NOT NULL CHECK (quantity > 0),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expires_at timestamp(0) with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS stock_reservations_product_id_idx ON stock_reservations (product_id, expires_at);
@@ -0,0 +1,3 @@
+ALTER TABLE products DROP COLUMN IF EXISTS rating_count;
+ALTER TABLE products DROP COLUMN IF EXISTS rating_average;
+DROP TABLE IF EXISTS reviews;
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS reviews (
+    id bigserial PRIMARY KEY,
+    product_id bigint NOT NULL REFERENCES products(product_id) ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    rating smallint NOT NULL CHECK (rating BETWEEN 1 AND 5),
+    comment text NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1,
+    UNIQUE (product_id, user_id)
+);
+
+-- The aggregated rating is stored on the product and refreshed whenever one of its reviews
+-- changes, so that product listings can be sorted by it without joining the reviews.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS rating_average DECIMAL(3, 2) NOT NULL DEFAULT 0;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS rating_count integer NOT NULL DEFAULT 0;
@@ -0,0 +1,14 @@
+-- storage_key is the prefix under which the original upload and its thumbnails are stored.
+CREATE TABLE IF NOT EXISTS product_images (
+    id bigserial PRIMARY KEY,
+    product_id bigint NOT NULL REFERENCES products(product_id) ON DELETE CASCADE,
+    position integer NOT NULL,
+    storage_key text NOT NULL,
+    content_type text NOT NULL,
+    size_bytes bigint NOT NULL,
+    width integer NOT NULL,
+    height integer NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS product_images_product_id_idx ON product_images (product_id, position);
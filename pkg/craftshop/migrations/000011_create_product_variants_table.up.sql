@@ -0,0 +1,22 @@
+-- The options schema of a product, e.g. size with the values S, M and L.
+CREATE TABLE IF NOT EXISTS product_options (
+    id bigserial PRIMARY KEY,
+    product_id bigint NOT NULL REFERENCES products(product_id) ON DELETE CASCADE,
+    name text NOT NULL,
+    option_values text[] NOT NULL,
+    position integer NOT NULL,
+    UNIQUE (product_id, name)
+);
+
+-- A variant picks one value of every option of its product, e.g. {"size": "M", "glaze": "blue"}.
+-- A NULL price means that the variant is sold at the price of the product.
+CREATE TABLE IF NOT EXISTS product_variants (
+    id bigserial PRIMARY KEY,
+    product_id bigint NOT NULL REFERENCES products(product_id) ON DELETE CASCADE,
+    sku citext UNIQUE NOT NULL,
+    options jsonb NOT NULL DEFAULT '{}',
+    price DECIMAL(10, 2),
+    stock integer NOT NULL DEFAULT 0 CHECK (stock >= 0)
+);
+
+CREATE INDEX IF NOT EXISTS product_variants_product_id_idx ON product_variants (product_id);
@@ -0,0 +1,4 @@
+UPDATE sellers SET password = '' WHERE password IS NULL;
+ALTER TABLE sellers ALTER COLUMN password SET NOT NULL;
+
+ALTER TABLE sellers DROP COLUMN IF EXISTS user_id;
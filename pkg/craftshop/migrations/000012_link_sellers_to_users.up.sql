@@ -0,0 +1,7 @@
+-- A seller profile belongs to exactly one user account. Existing sellers stay unlinked (and can
+-- only be managed by users with the sellers:write permission) until they are claimed.
+ALTER TABLE sellers ADD COLUMN IF NOT EXISTS user_id bigint UNIQUE REFERENCES users ON DELETE SET NULL;
+
+-- Sellers authenticate through their user account, so the separate seller password is no longer
+-- written.
+ALTER TABLE sellers ALTER COLUMN password DROP NOT NULL;
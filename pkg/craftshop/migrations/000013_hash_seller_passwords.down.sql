@@ -0,0 +1,5 @@
+-- The plaintext passwords can't be recovered, so the restored column stays empty. User accounts
+-- created for existing sellers are kept.
+ALTER TABLE sellers ADD COLUMN IF NOT EXISTS password VARCHAR(100);
+
+ALTER TABLE sellers DROP COLUMN IF EXISTS password_hash;
@@ -0,0 +1,28 @@
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+
+ALTER TABLE sellers ADD COLUMN IF NOT EXISTS password_hash bytea;
+
+-- Hash the plaintext passwords with bcrypt at the cost used for users. Go's bcrypt package accepts
+-- the $2a$ hashes produced by pgcrypto.
+UPDATE sellers
+SET password_hash = convert_to(crypt(password, gen_salt('bf', 12)), 'UTF8')
+WHERE password IS NOT NULL AND password <> '';
+
+ALTER TABLE sellers DROP COLUMN IF EXISTS password;
+
+-- Sellers which aren't attached to a user account yet get one with the same email address and
+-- password, so that they can still sign in. Sellers whose email address is already used by a user
+-- account are left unlinked.
+WITH created AS (
+    INSERT INTO users (created_at, name, email, password_hash, activated)
+    SELECT DISTINCT ON (lower(email)) COALESCE(date_joined, NOW()), seller_name, email, password_hash, true
+    FROM sellers
+    WHERE user_id IS NULL AND password_hash IS NOT NULL
+    ORDER BY lower(email), seller_id
+    ON CONFLICT (email) DO NOTHING
+    RETURNING id, email
+)
+UPDATE sellers
+SET user_id = created.id
+FROM created
+WHERE sellers.email = created.email::text;
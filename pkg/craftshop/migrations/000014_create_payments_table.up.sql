@@ -0,0 +1,32 @@
+-- A payment for a buy-now purchase of a product. Amounts are stored in minor units of the
+-- currency, e.g. cents, and the price is copied at purchase time.
+CREATE TABLE IF NOT EXISTS payments (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    product_id bigint REFERENCES products(product_id) ON DELETE SET NULL,
+    seller_id bigint REFERENCES sellers(seller_id) ON DELETE SET NULL,
+    quantity integer NOT NULL CHECK (quantity > 0),
+    amount bigint NOT NULL CHECK (amount > 0),
+    currency text NOT NULL DEFAULT 'USD',
+    provider text NOT NULL,
+    provider_intent_id text,
+    status text NOT NULL DEFAULT 'pending',
+    failure_reason text NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1,
+    UNIQUE (provider, provider_intent_id)
+);
+
+CREATE INDEX IF NOT EXISTS payments_user_id_idx ON payments (user_id);
+
+-- Every processed webhook event is recorded, so that events delivered more than once are applied
+-- only once.
+CREATE TABLE IF NOT EXISTS payment_events (
+    provider text NOT NULL,
+    event_id text NOT NULL,
+    payment_id bigint NOT NULL REFERENCES payments ON DELETE CASCADE,
+    type text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (provider, event_id)
+);
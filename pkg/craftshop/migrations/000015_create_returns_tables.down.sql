@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS return_events;
+DROP TABLE IF EXISTS return_attachments;
+DROP TABLE IF EXISTS returns;
+DROP TABLE IF EXISTS purchases;
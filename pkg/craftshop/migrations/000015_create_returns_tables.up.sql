@@ -0,0 +1,55 @@
+-- A purchase is what a user has paid for a product, either through a captured buy-now payment or
+-- as an item of a paid order. Returns are requested against purchases.
+CREATE TABLE IF NOT EXISTS purchases (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    product_id bigint REFERENCES products(product_id) ON DELETE SET NULL,
+    seller_id bigint REFERENCES sellers(seller_id) ON DELETE SET NULL,
+    payment_id bigint UNIQUE REFERENCES payments ON DELETE SET NULL,
+    order_item_id bigint UNIQUE REFERENCES order_items ON DELETE SET NULL,
+    quantity integer NOT NULL CHECK (quantity > 0),
+    amount bigint NOT NULL CHECK (amount >= 0),
+    currency text NOT NULL DEFAULT 'USD',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS purchases_user_id_idx ON purchases (user_id);
+CREATE INDEX IF NOT EXISTS purchases_seller_id_idx ON purchases (seller_id);
+
+CREATE TABLE IF NOT EXISTS returns (
+    id bigserial PRIMARY KEY,
+    purchase_id bigint NOT NULL REFERENCES purchases ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    reason text NOT NULL,
+    status text NOT NULL DEFAULT 'requested',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1
+);
+
+-- A purchase can only have one return which hasn't been rejected.
+CREATE UNIQUE INDEX IF NOT EXISTS returns_purchase_id_open_idx ON returns (purchase_id) WHERE status <> 'rejected';
+
+CREATE TABLE IF NOT EXISTS return_attachments (
+    id bigserial PRIMARY KEY,
+    return_id bigint NOT NULL REFERENCES returns ON DELETE CASCADE,
+    storage_key text NOT NULL,
+    content_type text NOT NULL,
+    size_bytes bigint NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS return_attachments_return_id_idx ON return_attachments (return_id);
+
+-- The audit trail of a return: one entry for the request and every later transition.
+CREATE TABLE IF NOT EXISTS return_events (
+    id bigserial PRIMARY KEY,
+    return_id bigint NOT NULL REFERENCES returns ON DELETE CASCADE,
+    from_status text,
+    to_status text NOT NULL,
+    changed_by bigint REFERENCES users ON DELETE SET NULL,
+    note text NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS return_events_return_id_idx ON return_events (return_id);
@@ -0,0 +1,28 @@
+-- Double-entry journal. The lines of an entry sum to zero; amounts are in minor units of the
+-- currency, positive for credits and negative for debits.
+CREATE TABLE IF NOT EXISTS journal_entries (
+    id bigserial PRIMARY KEY,
+    reference text UNIQUE NOT NULL,
+    description text NOT NULL DEFAULT '',
+    currency text NOT NULL DEFAULT 'USD',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS journal_entries_created_at_idx ON journal_entries (created_at);
+
+CREATE TABLE IF NOT EXISTS journal_lines (
+    id bigserial PRIMARY KEY,
+    entry_id bigint NOT NULL REFERENCES journal_entries ON DELETE RESTRICT,
+    account text NOT NULL,
+    amount bigint NOT NULL CHECK (amount <> 0)
+);
+
+CREATE INDEX IF NOT EXISTS journal_lines_account_idx ON journal_lines (account);
+CREATE INDEX IF NOT EXISTS journal_lines_entry_id_idx ON journal_lines (entry_id);
+
+CREATE TABLE IF NOT EXISTS payout_batches (
+    id bigserial PRIMARY KEY,
+    seller_count integer NOT NULL DEFAULT 0,
+    total bigint NOT NULL DEFAULT 0,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
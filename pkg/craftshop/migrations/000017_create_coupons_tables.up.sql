@@ -0,0 +1,38 @@
+-- A coupon takes a percentage (value in percent) or a fixed amount (value in cents) off the
+-- eligible items of an order, or makes its shipping free. Eligible items can be restricted to a
+-- category and/or a seller. A max_uses or max_uses_per_user of 0 means unlimited.
+CREATE TABLE IF NOT EXISTS coupons (
+    id bigserial PRIMARY KEY,
+    code citext UNIQUE NOT NULL,
+    kind text NOT NULL,
+    value bigint NOT NULL DEFAULT 0 CHECK (value >= 0),
+    category text NOT NULL DEFAULT '',
+    seller_id bigint REFERENCES sellers(seller_id) ON DELETE CASCADE,
+    min_order bigint NOT NULL DEFAULT 0 CHECK (min_order >= 0),
+    max_uses integer NOT NULL DEFAULT 0 CHECK (max_uses >= 0),
+    max_uses_per_user integer NOT NULL DEFAULT 0 CHECK (max_uses_per_user >= 0),
+    starts_at timestamp(0) with time zone,
+    ends_at timestamp(0) with time zone,
+    active boolean NOT NULL DEFAULT true,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS coupon_redemptions (
+    id bigserial PRIMARY KEY,
+    coupon_id bigint NOT NULL REFERENCES coupons ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    order_id bigint UNIQUE REFERENCES orders ON DELETE SET NULL,
+    discount bigint NOT NULL CHECK (discount >= 0),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS coupon_redemptions_coupon_id_user_id_idx ON coupon_redemptions (coupon_id, user_id);
+
+-- The discount of an order is allocated to its items, so that every purchase records what was
+-- actually paid for it.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS discount DECIMAL(10, 2) NOT NULL DEFAULT 0;
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS discount DECIMAL(10, 2) NOT NULL DEFAULT 0;
+
+INSERT INTO permissions (code)
+VALUES ('coupons:write');
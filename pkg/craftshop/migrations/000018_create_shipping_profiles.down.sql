@@ -0,0 +1,6 @@
+ALTER TABLE products DROP COLUMN IF EXISTS weight_grams;
+ALTER TABLE products DROP COLUMN IF EXISTS shipping_profile_id;
+ALTER TABLE products RENAME COLUMN shipping_note TO shipping_details;
+
+DROP TABLE IF EXISTS shipping_zones;
+DROP TABLE IF EXISTS shipping_profiles;
@@ -0,0 +1,42 @@
+-- A shipping profile describes how a seller ships: how long the items take to be prepared and
+-- what shipping costs in every zone. Products refer to a profile instead of describing their
+-- shipping in free text.
+CREATE TABLE IF NOT EXISTS shipping_profiles (
+    id bigserial PRIMARY KEY,
+    seller_id bigint NOT NULL REFERENCES sellers(seller_id) ON DELETE CASCADE,
+    name text NOT NULL,
+    processing_days_min integer NOT NULL DEFAULT 1 CHECK (processing_days_min >= 0),
+    processing_days_max integer NOT NULL DEFAULT 3 CHECK (processing_days_max >= processing_days_min),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS shipping_profiles_seller_id_idx ON shipping_profiles (seller_id);
+
+-- A zone covers a list of countries (ISO 3166-1 alpha-2 codes, or '*' for everywhere else),
+-- optionally narrowed down to regions (ISO 3166-2 codes such as 'US-CA'). Rates are in cents:
+-- a flat rate charges base_rate plus extra_item_rate for every further item, a weight rate
+-- charges base_rate plus per_kg_rate for every started kilogram. Orders of at least free_over
+-- cents ship for free, unless free_over is 0.
+CREATE TABLE IF NOT EXISTS shipping_zones (
+    id bigserial PRIMARY KEY,
+    profile_id bigint NOT NULL REFERENCES shipping_profiles ON DELETE CASCADE,
+    name text NOT NULL,
+    countries text[] NOT NULL,
+    regions text[] NOT NULL DEFAULT '{}',
+    rate_type text NOT NULL,
+    base_rate bigint NOT NULL DEFAULT 0 CHECK (base_rate >= 0),
+    extra_item_rate bigint NOT NULL DEFAULT 0 CHECK (extra_item_rate >= 0),
+    per_kg_rate bigint NOT NULL DEFAULT 0 CHECK (per_kg_rate >= 0),
+    free_over bigint NOT NULL DEFAULT 0 CHECK (free_over >= 0),
+    transit_days_min integer NOT NULL DEFAULT 1 CHECK (transit_days_min >= 0),
+    transit_days_max integer NOT NULL DEFAULT 7 CHECK (transit_days_max >= transit_days_min),
+    position integer NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS shipping_zones_profile_id_idx ON shipping_zones (profile_id);
+
+-- The old free-text shipping details are kept as a note shown next to the shipping profile.
+ALTER TABLE products RENAME COLUMN shipping_details TO shipping_note;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS shipping_profile_id bigint REFERENCES shipping_profiles ON DELETE SET NULL;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS weight_grams integer NOT NULL DEFAULT 0 CHECK (weight_grams >= 0);
@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'tax:write';
+
+DROP TABLE IF EXISTS tax_rules;
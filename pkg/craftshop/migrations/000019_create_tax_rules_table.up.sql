@@ -0,0 +1,19 @@
+-- A tax rule sets the rate (in basis points) for a destination country ('*' for everywhere),
+-- optionally narrowed down to a region and a product category. Inclusive rates are already
+-- contained in the product prices.
+CREATE TABLE IF NOT EXISTS tax_rules (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL,
+    country text NOT NULL,
+    region text NOT NULL DEFAULT '',
+    category text NOT NULL DEFAULT '',
+    rate_bps integer NOT NULL CHECK (rate_bps BETWEEN 0 AND 10000),
+    inclusive boolean NOT NULL DEFAULT false,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS tax_rules_destination_idx ON tax_rules (country, region, lower(category));
+
+INSERT INTO permissions (code)
+VALUES ('tax:write');
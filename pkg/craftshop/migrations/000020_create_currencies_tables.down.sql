@@ -0,0 +1,5 @@
+DELETE FROM permissions WHERE code = 'currencies:write';
+
+DROP TABLE IF EXISTS exchange_rates;
+
+DROP TABLE IF EXISTS currencies;
@@ -0,0 +1,26 @@
+-- Prices are stored in the base currency of the shop and converted for display with the exchange
+-- rates below. Rates are never updated or deleted: a new rate is added with the time it takes
+-- effect, so the rate used for any past conversion can be looked up again.
+CREATE TABLE IF NOT EXISTS currencies (
+    code text PRIMARY KEY CHECK (code ~ '^[A-Z]{3}$'),
+    name text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+-- rate is the amount of the currency paid for one unit of the base currency.
+CREATE TABLE IF NOT EXISTS exchange_rates (
+    id bigserial PRIMARY KEY,
+    currency text NOT NULL REFERENCES currencies ON DELETE CASCADE,
+    rate numeric(20, 10) NOT NULL CHECK (rate > 0),
+    effective_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS exchange_rates_currency_idx ON exchange_rates (currency, effective_at DESC);
+
+INSERT INTO currencies (code, name)
+VALUES ('USD', 'US Dollar')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO permissions (code)
+VALUES ('currencies:write');
@@ -0,0 +1,7 @@
+ALTER TABLE products DROP COLUMN IF EXISTS favourited_count;
+
+DROP TABLE IF EXISTS favourite_sellers;
+
+DROP TABLE IF EXISTS wishlist_items;
+
+DROP TABLE IF EXISTS wishlists;
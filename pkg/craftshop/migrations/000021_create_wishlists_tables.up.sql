@@ -0,0 +1,35 @@
+-- Users keep any number of named wishlists of products. A wishlist with a share token can be
+-- viewed by anyone who has the token. The price of a product is recorded when it is saved, so the
+-- change since then can be shown.
+CREATE TABLE IF NOT EXISTS wishlists (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    name text NOT NULL,
+    share_token text UNIQUE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS wishlists_user_name_idx ON wishlists (user_id, lower(name));
+
+CREATE TABLE IF NOT EXISTS wishlist_items (
+    wishlist_id bigint NOT NULL REFERENCES wishlists ON DELETE CASCADE,
+    product_id bigint NOT NULL REFERENCES products ON DELETE CASCADE,
+    saved_price DECIMAL(10, 2) NOT NULL,
+    added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (wishlist_id, product_id)
+);
+
+CREATE INDEX IF NOT EXISTS wishlist_items_product_idx ON wishlist_items (product_id);
+
+CREATE TABLE IF NOT EXISTS favourite_sellers (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    seller_id bigint NOT NULL REFERENCES sellers ON DELETE CASCADE,
+    added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, seller_id)
+);
+
+-- The number of users who saved a product in at least one of their wishlists, kept up to date
+-- like the rating of the product.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS favourited_count integer NOT NULL DEFAULT 0;
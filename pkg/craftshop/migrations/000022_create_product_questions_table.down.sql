@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'questions:moderate';
+
+DROP TABLE IF EXISTS product_questions;
@@ -0,0 +1,23 @@
+-- Buyers ask questions about a product and the seller of the product answers them. Moderators can
+-- hide an abusive question, which hides the whole thread, or just its answer.
+CREATE TABLE IF NOT EXISTS product_questions (
+    id bigserial PRIMARY KEY,
+    product_id bigint NOT NULL REFERENCES products(product_id) ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    question text NOT NULL,
+    answer text NOT NULL DEFAULT '',
+    answered_by bigint REFERENCES users ON DELETE SET NULL,
+    answered_at timestamp(0) with time zone,
+    hidden boolean NOT NULL DEFAULT false,
+    answer_hidden boolean NOT NULL DEFAULT false,
+    moderated_by bigint REFERENCES users ON DELETE SET NULL,
+    moderated_at timestamp(0) with time zone,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS product_questions_product_idx ON product_questions (product_id, created_at);
+
+INSERT INTO permissions (code)
+VALUES ('questions:moderate');
@@ -0,0 +1,6 @@
+-- The plaintext of hashed tokens can't be recovered, so every token is revoked.
+DELETE FROM tokens;
+
+ALTER TABLE tokens DROP CONSTRAINT IF EXISTS tokens_pkey;
+ALTER TABLE tokens DROP COLUMN IF EXISTS hash;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS plaintext text PRIMARY KEY;
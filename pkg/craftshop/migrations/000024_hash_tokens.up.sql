@@ -0,0 +1,10 @@
+-- Tokens are stored as SHA-256 hashes, so a copy of the database doesn't contain usable tokens.
+-- Existing tokens keep working since the hash is computed from their plaintext.
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS hash bytea;
+
+UPDATE tokens SET hash = sha256(convert_to(plaintext, 'UTF8'));
+
+ALTER TABLE tokens ALTER COLUMN hash SET NOT NULL;
+ALTER TABLE tokens DROP CONSTRAINT IF EXISTS tokens_pkey;
+ALTER TABLE tokens DROP COLUMN IF EXISTS plaintext;
+ALTER TABLE tokens ADD PRIMARY KEY (hash);
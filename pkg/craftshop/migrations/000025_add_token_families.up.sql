@@ -0,0 +1,9 @@
+-- A login issues an authentication token and a refresh token of a new family. Rotating the
+-- refresh token issues new tokens of the same family and marks the old refresh token as used, so
+-- that if it's ever replayed the whole family can be revoked.
+CREATE SEQUENCE IF NOT EXISTS token_families_seq;
+
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS family_id bigint;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS used_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS tokens_family_id_idx ON tokens (family_id);
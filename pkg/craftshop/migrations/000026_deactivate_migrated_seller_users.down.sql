@@ -0,0 +1,2 @@
+-- Which accounts were deactivated isn't recorded, so they stay unactivated.
+SELECT 1;
@@ -0,0 +1,13 @@
+-- User accounts which were created for existing sellers with the seller password were activated
+-- without anybody proving that they own the email address. They are deactivated and signed out,
+-- and have to reset their password to use the account again.
+DELETE FROM tokens
+USING sellers, users
+WHERE tokens.users_id = sellers.user_id AND users.id = sellers.user_id
+    AND users.activated AND users.password_hash = sellers.password_hash
+    AND tokens.scope IN ('authentication', 'refresh');
+
+UPDATE users
+SET activated = false, version = version + 1
+FROM sellers
+WHERE sellers.user_id = users.id AND users.activated AND sellers.password_hash = users.password_hash;
@@ -0,0 +1,8 @@
+DELETE FROM coupon_redemptions WHERE coupon_id IS NULL;
+
+ALTER TABLE coupon_redemptions DROP CONSTRAINT IF EXISTS coupon_redemptions_coupon_id_fkey;
+ALTER TABLE coupon_redemptions ADD CONSTRAINT coupon_redemptions_coupon_id_fkey
+    FOREIGN KEY (coupon_id) REFERENCES coupons ON DELETE CASCADE;
+ALTER TABLE coupon_redemptions ALTER COLUMN coupon_id SET NOT NULL;
+
+ALTER TABLE orders DROP COLUMN IF EXISTS coupon_code;
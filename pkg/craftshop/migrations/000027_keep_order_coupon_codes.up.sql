@@ -0,0 +1,14 @@
+-- Orders keep the code of the coupon they redeemed, so that deleting a coupon doesn't erase it
+-- from past orders. The redemptions of a deleted coupon are kept without the coupon.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS coupon_code text NOT NULL DEFAULT '';
+
+UPDATE orders
+SET coupon_code = coupons.code
+FROM coupon_redemptions
+INNER JOIN coupons ON coupons.id = coupon_redemptions.coupon_id
+WHERE coupon_redemptions.order_id = orders.id;
+
+ALTER TABLE coupon_redemptions ALTER COLUMN coupon_id DROP NOT NULL;
+ALTER TABLE coupon_redemptions DROP CONSTRAINT IF EXISTS coupon_redemptions_coupon_id_fkey;
+ALTER TABLE coupon_redemptions ADD CONSTRAINT coupon_redemptions_coupon_id_fkey
+    FOREIGN KEY (coupon_id) REFERENCES coupons ON DELETE SET NULL;
@@ -0,0 +1,4 @@
+-- decimals is the number of decimal places amounts of the currency are rounded to, e.g. 0 for JPY.
+-- Amounts are kept in hundredths, so currencies with more than two decimal places aren't supported.
+ALTER TABLE currencies ADD COLUMN IF NOT EXISTS decimals smallint NOT NULL DEFAULT 2
+    CHECK (decimals BETWEEN 0 AND 2);
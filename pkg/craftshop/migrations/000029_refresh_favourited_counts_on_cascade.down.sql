@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS wishlist_items_refresh_favourited_count ON wishlist_items;
+DROP FUNCTION IF EXISTS refresh_favourited_count();
@@ -0,0 +1,35 @@
+-- The favourited count of a product is recalculated by the application whenever an item is
+-- saved or removed. Items deleted by a cascade, e.g. when a user and with them their wishlists
+-- are deleted, never pass through the application, so they are recounted by this trigger. Direct
+-- deletes run at trigger depth 0 and are left to the application.
+CREATE OR REPLACE FUNCTION refresh_favourited_count() RETURNS trigger AS $$
+BEGIN
+    UPDATE products
+    SET favourited_count = (
+        SELECT COUNT(DISTINCT wishlists.user_id)
+        FROM wishlist_items
+        INNER JOIN wishlists ON wishlists.id = wishlist_items.wishlist_id
+        WHERE wishlist_items.product_id = products.product_id
+    )
+    WHERE product_id = OLD.product_id;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS wishlist_items_refresh_favourited_count ON wishlist_items;
+
+CREATE TRIGGER wishlist_items_refresh_favourited_count
+    AFTER DELETE ON wishlist_items
+    FOR EACH ROW
+    WHEN (pg_trigger_depth() > 0)
+    EXECUTE FUNCTION refresh_favourited_count();
+
+-- Fix the counts of products saved by users who have been deleted already.
+UPDATE products
+SET favourited_count = (
+    SELECT COUNT(DISTINCT wishlists.user_id)
+    FROM wishlist_items
+    INNER JOIN wishlists ON wishlists.id = wishlist_items.wishlist_id
+    WHERE wishlist_items.product_id = products.product_id
+);
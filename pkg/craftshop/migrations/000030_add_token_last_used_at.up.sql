@@ -0,0 +1,3 @@
+-- Rotating a refresh token issues the new tokens of a login with the created_at of the tokens they
+-- replace, so a session keeps the time it was logged in. last_used_at records the last rotation.
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS last_used_at timestamp(0) with time zone;
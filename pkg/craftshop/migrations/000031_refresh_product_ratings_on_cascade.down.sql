@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS reviews_refresh_product_rating ON reviews;
+DROP FUNCTION IF EXISTS refresh_product_rating();
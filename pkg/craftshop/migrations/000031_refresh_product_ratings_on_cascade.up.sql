@@ -0,0 +1,27 @@
+-- The aggregated rating of a product is recalculated by the application whenever a review is
+-- written or deleted. Reviews deleted by a cascade, e.g. when their author is deleted, never pass
+-- through the application, so they are recounted by this trigger. Direct deletes run at trigger
+-- depth 0 and are left to the application.
+CREATE OR REPLACE FUNCTION refresh_product_rating() RETURNS trigger AS $$
+BEGIN
+    UPDATE products
+    SET rating_average = COALESCE((SELECT ROUND(AVG(rating), 2) FROM reviews WHERE product_id = OLD.product_id), 0),
+        rating_count = (SELECT COUNT(*) FROM reviews WHERE product_id = OLD.product_id)
+    WHERE product_id = OLD.product_id;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS reviews_refresh_product_rating ON reviews;
+
+CREATE TRIGGER reviews_refresh_product_rating
+    AFTER DELETE ON reviews
+    FOR EACH ROW
+    WHEN (pg_trigger_depth() > 0)
+    EXECUTE FUNCTION refresh_product_rating();
+
+-- Fix the ratings of products reviewed by users who have been deleted already.
+UPDATE products
+SET rating_average = COALESCE((SELECT ROUND(AVG(rating), 2) FROM reviews WHERE reviews.product_id = products.product_id), 0),
+    rating_count = (SELECT COUNT(*) FROM reviews WHERE reviews.product_id = products.product_id);
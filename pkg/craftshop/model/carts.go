@@ -0,0 +1,236 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/validator"
+	"github.com/ainelnazaraly/CraftShop/pkg/money"
+)
+
+// Cart is the persistent shopping cart of a single user. A cart is created lazily the first time
+// it is requested and is never removed on logout, so its contents survive between sessions.
+type Cart struct {
+	ID        int64       `json:"cart_id"`
+	UserID    int64       `json:"user_id"`
+	Items     []*CartItem `json:"items"`
+	Total     money.Money `json:"total"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+// CartItem is a single product line in a cart. Name and Price are read from the products table
+// every time the cart is loaded, so the subtotal always reflects the current product price.
+type CartItem struct {
+	ProductID int         `json:"product_id"`
+	Name      string      `json:"product_name"`
+	Price     money.Money `json:"price"`
+	Quantity  int         `json:"quantity"`
+	Subtotal  money.Money `json:"subtotal"`
+	AddedAt   time.Time   `json:"added_at"`
+}
+
+// MaxCartItemQuantity is the most units of a single product a cart can hold.
+const MaxCartItemQuantity = 1000
+
+type CartModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateCartItem(v *validator.Validator, item *CartItem) {
+	v.Check(item.ProductID > 0, "product_id", "must be provided")
+	v.Check(item.Quantity > 0, "quantity", "must be greater than zero")
+	v.Check(item.Quantity <= MaxCartItemQuantity, "quantity", fmt.Sprintf("must not be more than %d", MaxCartItemQuantity))
+}
+
+// Get returns the cart of the given user together with its items, creating an empty cart first
+// if the user doesn't have one yet.
+func (m CartModel) Get(userID int64) (*Cart, error) {
+	// The no-op DO UPDATE makes RETURNING work for both the insert and the conflict case.
+	query := `
+		INSERT INTO carts (user_id)
+		VALUES ($1)
+		ON CONFLICT (user_id) DO UPDATE SET user_id = EXCLUDED.user_id
+		RETURNING id, user_id, created_at, updated_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var cart Cart
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&cart.ID, &cart.UserID, &cart.CreatedAt, &cart.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT cart_items.product_id, products.product_name, products.price, cart_items.quantity, cart_items.added_at
+		FROM cart_items
+		INNER JOIN products ON products.product_id = cart_items.product_id
+		WHERE cart_items.cart_id = $1
+		ORDER BY cart_items.added_at, cart_items.product_id`
+
+	rows, err := m.DB.QueryContext(ctx, query, cart.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	cart.Items = []*CartItem{}
+	cart.Total = money.New(0, money.DefaultCurrency)
+
+	for rows.Next() {
+		var item CartItem
+
+		err := rows.Scan(&item.ProductID, &item.Name, &item.Price, &item.Quantity, &item.AddedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		item.Subtotal = item.Price.Mul(item.Quantity)
+		cart.Total = cart.Total.Add(item.Subtotal)
+		cart.Items = append(cart.Items, &item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &cart, nil
+}
+
+// AddItem puts quantity units of a product into the cart. If the product is already in the cart
+// the quantities are added together, up to MaxCartItemQuantity.
+func (m CartModel) AddItem(cartID int64, item *CartItem) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = addCartItem(ctx, tx, cartID, item)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Merge adds every item of an anonymous session cart to the user's persistent cart in a single
+// transaction, summing the quantities of products that are present in both up to
+// MaxCartItemQuantity.
+func (m CartModel) Merge(cartID int64, items []*CartItem) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, item := range items {
+		err = addCartItem(ctx, tx, cartID, item)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SetQuantity replaces the quantity of a product which is already in the cart.
+func (m CartModel) SetQuantity(cartID int64, item *CartItem) error {
+	query := `
+		UPDATE cart_items
+		SET quantity = $1
+		WHERE cart_id = $2 AND product_id = $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, item.Quantity, cartID, item.ProductID)
+	if err != nil {
+		return err
+	}
+
+	return m.touch(ctx, cartID, result)
+}
+
+// RemoveItem deletes a product from the cart.
+func (m CartModel) RemoveItem(cartID int64, productID int) error {
+	query := `
+		DELETE FROM cart_items
+		WHERE cart_id = $1 AND product_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, cartID, productID)
+	if err != nil {
+		return err
+	}
+
+	return m.touch(ctx, cartID, result)
+}
+
+// Clear removes every item from the cart.
+func (m CartModel) Clear(cartID int64) error {
+	query := `
+		DELETE FROM cart_items
+		WHERE cart_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, cartID)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.DB.ExecContext(ctx, `UPDATE carts SET updated_at = NOW() WHERE id = $1`, cartID)
+	return err
+}
+
+// touch bumps updated_at of the cart if the preceding statement changed a cart item, and returns
+// ErrRecordNotFound if it didn't.
+func (m CartModel) touch(ctx context.Context, cartID int64, result sql.Result) error {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	_, err = m.DB.ExecContext(ctx, `UPDATE carts SET updated_at = NOW() WHERE id = $1`, cartID)
+	return err
+}
+
+func addCartItem(ctx context.Context, tx *sql.Tx, cartID int64, item *CartItem) error {
+	query := `
+		INSERT INTO cart_items (cart_id, product_id, quantity)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (cart_id, product_id) DO UPDATE SET quantity = LEAST(cart_items.quantity + EXCLUDED.quantity, $4)`
+
+	_, err := tx.ExecContext(ctx, query, cartID, item.ProductID, item.Quantity, MaxCartItemQuantity)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE carts SET updated_at = NOW() WHERE id = $1`, cartID)
+	return err
+}
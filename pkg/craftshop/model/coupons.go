@@ -0,0 +1,549 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/validator"
+	"github.com/ainelnazaraly/CraftShop/pkg/money"
+	"github.com/lib/pq"
+)
+
+const (
+	CouponKindPercentage   = "percentage"
+	CouponKindFixed        = "fixed"
+	CouponKindFreeShipping = "free_shipping"
+)
+
+// CouponCodeRX matches the codes which can be given to coupons.
+var CouponCodeRX = regexp.MustCompile("^[A-Za-z0-9_-]+$")
+
+// Coupon is a discount code. A percentage coupon takes Value percent off the eligible items, a
+// fixed coupon takes Value cents off them and a free shipping coupon makes the shipping of the
+// items free when it is applied to a shipping quote. Items are eligible if they match Category
+// and SellerID, when those are set. MinOrder is compared with the subtotal of the eligible items.
+// A MaxUses or MaxUsesPerUser of 0 means that the coupon can be used any number of times, and nil
+// StartsAt or EndsAt mean that the coupon is valid from or until any time. Uses is the number of
+// times the coupon has been redeemed by orders which haven't been cancelled.
+type Coupon struct {
+	ID             int64      `json:"id"`
+	Code           string     `json:"code"`
+	Kind           string     `json:"kind"`
+	Value          int64      `json:"value"`
+	Category       string     `json:"category,omitempty"`
+	SellerID       int        `json:"seller_id,omitempty"`
+	MinOrder       int64      `json:"min_order"`
+	MaxUses        int        `json:"max_uses"`
+	MaxUsesPerUser int        `json:"max_uses_per_user"`
+	StartsAt       *time.Time `json:"starts_at,omitempty"`
+	EndsAt         *time.Time `json:"ends_at,omitempty"`
+	Active         bool       `json:"active"`
+	Uses           int        `json:"uses"`
+	CreatedAt      time.Time  `json:"created_at"`
+	Version        int        `json:"-"`
+}
+
+// CouponItem is an item priced with a coupon. Subtotal and Discount are in cents.
+type CouponItem struct {
+	ProductID int    `json:"product_id"`
+	SellerID  int    `json:"seller_id,omitempty"`
+	Category  string `json:"category"`
+	Quantity  int    `json:"quantity"`
+	Subtotal  int64  `json:"subtotal"`
+	Discount  int64  `json:"discount"`
+	Eligible  bool   `json:"eligible"`
+}
+
+// CouponQuote is the breakdown of the discount a coupon gives on a list of items. Amounts are in
+// minor units of Currency.
+type CouponQuote struct {
+	Code         string        `json:"code"`
+	Kind         string        `json:"kind"`
+	Subtotal     int64         `json:"subtotal"`
+	Discount     int64         `json:"discount"`
+	Total        int64         `json:"total"`
+	FreeShipping bool          `json:"free_shipping"`
+	Currency     string        `json:"currency"`
+	Items        []*CouponItem `json:"items"`
+
+	couponID int64
+}
+
+var (
+	// ErrDuplicateCoupon is returned when a coupon is saved with the code of another coupon.
+	ErrDuplicateCoupon = errors.New("duplicate coupon")
+
+	// ErrCouponNotApplicable is wrapped by the errors explaining why a coupon can't be applied to
+	// an order.
+	ErrCouponNotApplicable = errors.New("coupon cannot be applied")
+
+	errCouponUnknown     = fmt.Errorf("%w: it does not exist", ErrCouponNotApplicable)
+	errCouponInactive    = fmt.Errorf("%w: it is not active", ErrCouponNotApplicable)
+	errCouponNotStarted  = fmt.Errorf("%w: it is not valid yet", ErrCouponNotApplicable)
+	errCouponExpired     = fmt.Errorf("%w: it has expired", ErrCouponNotApplicable)
+	errCouponUsedUp      = fmt.Errorf("%w: it has been used up", ErrCouponNotApplicable)
+	errCouponUserUsedUp  = fmt.Errorf("%w: you have already used it the maximum number of times", ErrCouponNotApplicable)
+	errCouponNotEligible = fmt.Errorf("%w: none of the items are eligible", ErrCouponNotApplicable)
+	errCouponMinOrder    = fmt.Errorf("%w: the eligible items don't reach the minimum order value", ErrCouponNotApplicable)
+)
+
+type CouponModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateCoupon(v *validator.Validator, coupon *Coupon) {
+	v.Check(coupon.Code != "", "code", "must be provided")
+	v.Check(len(coupon.Code) <= 32, "code", "must not be more than 32 bytes long")
+	v.Check(validator.Matches(coupon.Code, CouponCodeRX), "code", "must only contain letters, digits, dashes and underscores")
+
+	v.Check(validator.In(coupon.Kind, CouponKindPercentage, CouponKindFixed, CouponKindFreeShipping), "kind", "must be percentage, fixed or free_shipping")
+
+	switch coupon.Kind {
+	case CouponKindPercentage:
+		v.Check(coupon.Value > 0 && coupon.Value <= 100, "value", "must be a percentage between 1 and 100")
+	case CouponKindFixed:
+		v.Check(coupon.Value > 0, "value", "must be greater than zero")
+	case CouponKindFreeShipping:
+		v.Check(coupon.Value == 0, "value", "must not be provided for free shipping coupons")
+	}
+
+	v.Check(len(coupon.Category) <= 100, "category", "must not be more than 100 characters long")
+	v.Check(coupon.SellerID >= 0, "seller_id", "must not be negative")
+	v.Check(coupon.MinOrder >= 0, "min_order", "must not be negative")
+	v.Check(coupon.MaxUses >= 0, "max_uses", "must not be negative")
+	v.Check(coupon.MaxUsesPerUser >= 0, "max_uses_per_user", "must not be negative")
+
+	if coupon.StartsAt != nil && coupon.EndsAt != nil {
+		v.Check(coupon.EndsAt.After(*coupon.StartsAt), "ends_at", "must be after starts_at")
+	}
+}
+
+// Eligible reports whether the coupon applies to the item.
+func (c *Coupon) Eligible(item *CouponItem) bool {
+	if c.Category != "" && !strings.EqualFold(c.Category, item.Category) {
+		return false
+	}
+
+	return c.SellerID == 0 || c.SellerID == item.SellerID
+}
+
+// Price applies the coupon to the items at the given time. The usage limits of the coupon aren't
+// checked, as that needs its redemptions. A fixed discount is spread over the eligible items in
+// proportion to their subtotals.
+func (c *Coupon) Price(items []*CouponItem, now time.Time) (*CouponQuote, error) {
+	switch {
+	case !c.Active:
+		return nil, errCouponInactive
+	case c.StartsAt != nil && now.Before(*c.StartsAt):
+		return nil, errCouponNotStarted
+	case c.EndsAt != nil && !now.Before(*c.EndsAt):
+		return nil, errCouponExpired
+	}
+
+	quote := &CouponQuote{
+		couponID: c.ID,
+		Code:     c.Code,
+		Kind:     c.Kind,
+		Currency: DefaultCurrency,
+		Items:    items,
+	}
+
+	var eligible []*CouponItem
+	var eligibleSubtotal int64
+
+	for _, item := range items {
+		item.Eligible = c.Eligible(item)
+		item.Discount = 0
+
+		quote.Subtotal += item.Subtotal
+
+		if item.Eligible {
+			eligible = append(eligible, item)
+			eligibleSubtotal += item.Subtotal
+		}
+	}
+
+	if len(eligible) == 0 {
+		return nil, errCouponNotEligible
+	}
+
+	if eligibleSubtotal < c.MinOrder {
+		return nil, errCouponMinOrder
+	}
+
+	switch c.Kind {
+	case CouponKindPercentage:
+		for _, item := range eligible {
+			item.Discount = (item.Subtotal*c.Value + 50) / 100
+			quote.Discount += item.Discount
+		}
+	case CouponKindFixed:
+		discount := c.Value
+		if discount > eligibleSubtotal {
+			discount = eligibleSubtotal
+		}
+
+		if eligibleSubtotal > 0 {
+			for _, item := range eligible {
+				item.Discount = discount * item.Subtotal / eligibleSubtotal
+				quote.Discount += item.Discount
+			}
+		}
+
+		// Give the cents lost to rounding to the last eligible item.
+		eligible[len(eligible)-1].Discount += discount - quote.Discount
+		quote.Discount = discount
+	case CouponKindFreeShipping:
+		quote.FreeShipping = true
+	}
+
+	quote.Total = quote.Subtotal - quote.Discount
+
+	return quote, nil
+}
+
+func (m CouponModel) Insert(coupon *Coupon) error {
+	query := `
+		INSERT INTO coupons (code, kind, value, category, seller_id, min_order, max_uses, max_uses_per_user, starts_at, ends_at, active)
+		VALUES ($1, $2, $3, $4, NULLIF($5, 0), $6, $7, $8, $9, $10, $11)
+		RETURNING id, created_at, version`
+
+	args := []interface{}{coupon.Code, coupon.Kind, coupon.Value, coupon.Category, coupon.SellerID, coupon.MinOrder,
+		coupon.MaxUses, coupon.MaxUsesPerUser, coupon.StartsAt, coupon.EndsAt, coupon.Active}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&coupon.ID, &coupon.CreatedAt, &coupon.Version)
+	if err != nil {
+		return couponWriteError(err)
+	}
+
+	return nil
+}
+
+func (m CouponModel) Get(id int64) (*Coupon, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM coupons
+		WHERE id = $1`, couponColumns)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	coupon, err := scanCoupon(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return coupon, nil
+}
+
+// GetAll returns a page of the coupons whose code contains search.
+func (m CouponModel) GetAll(search string, filters Filters) ([]*Coupon, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), %s
+		FROM coupons
+		WHERE (code ILIKE '%%' || $1 || '%%' OR $1 = '')
+		ORDER BY %s %s, id
+		LIMIT $2 OFFSET $3`, couponColumns, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, search, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	totalRecords := 0
+	coupons := []*Coupon{}
+
+	for rows.Next() {
+		var total int
+
+		coupon, err := scanCoupon(countingScanner{row: rows, count: &total})
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		totalRecords = total
+		coupons = append(coupons, coupon)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return coupons, metadata, nil
+}
+
+func (m CouponModel) Update(coupon *Coupon) error {
+	query := `
+		UPDATE coupons
+		SET code = $1, kind = $2, value = $3, category = $4, seller_id = NULLIF($5, 0), min_order = $6, max_uses = $7,
+			max_uses_per_user = $8, starts_at = $9, ends_at = $10, active = $11, version = version + 1
+		WHERE id = $12 AND version = $13
+		RETURNING version`
+
+	args := []interface{}{coupon.Code, coupon.Kind, coupon.Value, coupon.Category, coupon.SellerID, coupon.MinOrder,
+		coupon.MaxUses, coupon.MaxUsesPerUser, coupon.StartsAt, coupon.EndsAt, coupon.Active, coupon.ID, coupon.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&coupon.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return couponWriteError(err)
+		}
+	}
+
+	return nil
+}
+
+// Delete removes a coupon. Its redemptions are kept without the coupon, and the orders which
+// redeemed it keep its code.
+func (m CouponModel) Delete(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM coupons WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Quote prices the items with the coupon with the given code for the given user without
+// redeeming it. Every item only needs ProductID and Quantity set. If one of the products doesn't
+// exist ErrRecordNotFound is returned, and an error wrapping ErrCouponNotApplicable explains why
+// the coupon can't be used.
+func (m CouponModel) Quote(code string, userID int64, items []*CouponItem) (*CouponQuote, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT product_id, price, seller_id, category
+		FROM products
+		WHERE product_id = ANY($1)`
+
+	ids := make([]int, len(items))
+	for i, item := range items {
+		ids[i] = item.ProductID
+	}
+
+	rows, err := tx.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	type product struct {
+		price    money.Money
+		sellerID int
+		category string
+	}
+
+	products := make(map[int]product, len(items))
+
+	for rows.Next() {
+		var id int
+		var p product
+
+		if err := rows.Scan(&id, &p.price, &p.sellerID, &p.category); err != nil {
+			return nil, err
+		}
+
+		products[id] = p
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, item := range items {
+		p, ok := products[item.ProductID]
+		if !ok {
+			return nil, ErrRecordNotFound
+		}
+
+		item.SellerID = p.sellerID
+		item.Category = p.category
+		item.Subtotal = p.price.Mul(item.Quantity).Amount
+	}
+
+	return quoteCoupon(ctx, tx, code, userID, items, false)
+}
+
+// quoteCoupon prices the items with the coupon with the given code and checks that the user may
+// still use it. With lock set the coupon row stays locked until the end of the transaction, so
+// that concurrent redemptions can't exceed its usage limits.
+func quoteCoupon(ctx context.Context, tx *sql.Tx, code string, userID int64, items []*CouponItem, lock bool) (*CouponQuote, error) {
+	if lock {
+		// The coupon is locked by a statement of its own. Under READ COMMITTED a statement only
+		// sees the rows committed before it started, so the uses have to be counted by the
+		// following statements to include the redemptions of the transaction which held the lock
+		// before.
+		var id int64
+
+		err := tx.QueryRowContext(ctx, `SELECT id FROM coupons WHERE code = $1 FOR UPDATE`, code).Scan(&id)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return nil, errCouponUnknown
+			default:
+				return nil, err
+			}
+		}
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM coupons
+		WHERE code = $1`, couponColumns)
+
+	coupon, err := scanCoupon(tx.QueryRowContext(ctx, query, code))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, errCouponUnknown
+		default:
+			return nil, err
+		}
+	}
+
+	if coupon.MaxUses > 0 && coupon.Uses >= coupon.MaxUses {
+		return nil, errCouponUsedUp
+	}
+
+	if coupon.MaxUsesPerUser > 0 {
+		query := `
+			SELECT count(*)
+			FROM coupon_redemptions
+			LEFT JOIN orders ON orders.id = coupon_redemptions.order_id
+			WHERE coupon_redemptions.coupon_id = $1 AND coupon_redemptions.user_id = $2
+				AND orders.status IS DISTINCT FROM 'cancelled'`
+
+		var uses int
+
+		err := tx.QueryRowContext(ctx, query, coupon.ID, userID).Scan(&uses)
+		if err != nil {
+			return nil, err
+		}
+
+		if uses >= coupon.MaxUsesPerUser {
+			return nil, errCouponUserUsedUp
+		}
+	}
+
+	quote, err := coupon.Price(items, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return quote, nil
+}
+
+// insertCouponRedemption records that the coupon of the quote has been used by an order.
+func insertCouponRedemption(ctx context.Context, tx *sql.Tx, quote *CouponQuote, userID, orderID int64) error {
+	query := `
+		INSERT INTO coupon_redemptions (coupon_id, user_id, order_id, discount)
+		VALUES ($1, $2, $3, $4)`
+
+	_, err := tx.ExecContext(ctx, query, quote.couponID, userID, orderID, quote.Discount)
+	return err
+}
+
+// couponColumns are the columns read by scanCoupon. Redemptions of cancelled orders don't count
+// as uses.
+const couponColumns = `coupons.id, coupons.code, coupons.kind, coupons.value, coupons.category, COALESCE(coupons.seller_id, 0),
+	coupons.min_order, coupons.max_uses, coupons.max_uses_per_user, coupons.starts_at, coupons.ends_at, coupons.active,
+	(SELECT count(*) FROM coupon_redemptions
+		LEFT JOIN orders ON orders.id = coupon_redemptions.order_id
+		WHERE coupon_redemptions.coupon_id = coupons.id AND orders.status IS DISTINCT FROM 'cancelled'),
+	coupons.created_at, coupons.version`
+
+func scanCoupon(row rowScanner) (*Coupon, error) {
+	var coupon Coupon
+	var startsAt, endsAt sql.NullTime
+
+	err := row.Scan(&coupon.ID, &coupon.Code, &coupon.Kind, &coupon.Value, &coupon.Category, &coupon.SellerID,
+		&coupon.MinOrder, &coupon.MaxUses, &coupon.MaxUsesPerUser, &startsAt, &endsAt, &coupon.Active,
+		&coupon.Uses, &coupon.CreatedAt, &coupon.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	if startsAt.Valid {
+		coupon.StartsAt = &startsAt.Time
+	}
+
+	if endsAt.Valid {
+		coupon.EndsAt = &endsAt.Time
+	}
+
+	return &coupon, nil
+}
+
+// countingScanner reads the count(*) OVER() column of a listing before the columns of a row.
+type countingScanner struct {
+	row   rowScanner
+	count *int
+}
+
+func (s countingScanner) Scan(dest ...interface{}) error {
+	return s.row.Scan(append([]interface{}{s.count}, dest...)...)
+}
+
+func couponWriteError(err error) error {
+	switch {
+	case err.Error() == `pq: duplicate key value violates unique constraint "coupons_code_key"`:
+		return ErrDuplicateCoupon
+	case err.Error() == `pq: insert or update on table "coupons" violates foreign key constraint "coupons_seller_id_fkey"`:
+		return ErrRecordNotFound
+	default:
+		return err
+	}
+}
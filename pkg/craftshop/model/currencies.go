@@ -0,0 +1,339 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/validator"
+	"github.com/ainelnazaraly/CraftShop/pkg/money"
+)
+
+// Currency is a currency prices can be displayed in. Converted prices are rounded to Decimals
+// decimal places. Rate is the exchange rate in effect at the time the currency was read, if it has
+// one.
+type Currency struct {
+	Code      string        `json:"code"`
+	Name      string        `json:"name"`
+	Decimals  int           `json:"decimals"`
+	Rate      *ExchangeRate `json:"rate,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+// ExchangeRate is the amount of Currency paid for one unit of money.DefaultCurrency from
+// EffectiveAt until the next rate of the currency takes effect. Rate is a decimal string.
+// Decimals is copied from the currency when the rate is read with it.
+type ExchangeRate struct {
+	ID          int64     `json:"id"`
+	Currency    string    `json:"currency"`
+	Rate        string    `json:"rate"`
+	EffectiveAt time.Time `json:"effective_at"`
+	CreatedAt   time.Time `json:"created_at"`
+	Decimals    int       `json:"-"`
+}
+
+// ConvertedPrice is a price converted from the base currency of the shop for display, together
+// with the exchange rate used, so the conversion can be reproduced later.
+type ConvertedPrice struct {
+	Amount          money.Money `json:"amount"`
+	Currency        string      `json:"currency"`
+	BaseCurrency    string      `json:"base_currency"`
+	Rate            string      `json:"rate"`
+	RateID          int64       `json:"rate_id"`
+	RateEffectiveAt time.Time   `json:"rate_effective_at"`
+}
+
+var (
+	// ErrDuplicateCurrency is returned when a currency is added twice.
+	ErrDuplicateCurrency = errors.New("duplicate currency")
+)
+
+var (
+	// CurrencyRX matches ISO 4217 currency codes.
+	CurrencyRX = regexp.MustCompile(`^[A-Z]{3}$`)
+
+	// exchangeRateRX matches the rates the numeric(20, 10) rate column holds.
+	exchangeRateRX = regexp.MustCompile(`^[0-9]{1,10}(\.[0-9]{1,10})?$`)
+)
+
+type CurrencyModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateCurrency(v *validator.Validator, currency *Currency) {
+	v.Check(validator.Matches(currency.Code, CurrencyRX), "code", "must be an ISO 4217 currency code")
+	v.Check(currency.Name != "", "name", "must be provided")
+	v.Check(len(currency.Name) <= 100, "name", "must not be more than 100 characters long")
+	v.Check(currency.Decimals >= 0 && currency.Decimals <= 2, "decimals", "must be between 0 and 2")
+}
+
+// ValidateExchangeRate checks a new rate. Rates may be scheduled for the future but not
+// backdated, since that would change conversions which have already been shown.
+func ValidateExchangeRate(v *validator.Validator, rate *ExchangeRate, now time.Time) {
+	v.Check(rate.Currency != money.DefaultCurrency, "currency", "must not be the base currency")
+	v.Check(validator.Matches(rate.Rate, exchangeRateRX), "rate", "must be a decimal number with at most 10 decimal places")
+
+	if r, ok := new(big.Rat).SetString(rate.Rate); ok {
+		v.Check(r.Sign() > 0, "rate", "must be greater than zero")
+	}
+
+	v.Check(!rate.EffectiveAt.Before(now.Add(-time.Minute)), "effective_at", "must not be in the past")
+}
+
+// Convert converts a price in the base currency of the shop with the rate.
+func (r *ExchangeRate) Convert(price money.Money) (*ConvertedPrice, error) {
+	rate, ok := new(big.Rat).SetString(r.Rate)
+	if !ok {
+		return nil, fmt.Errorf("invalid exchange rate %q", r.Rate)
+	}
+
+	return &ConvertedPrice{
+		Amount:          price.Convert(r.Currency, rate, r.Decimals),
+		Currency:        r.Currency,
+		BaseCurrency:    price.Currency,
+		Rate:            r.Rate,
+		RateID:          r.ID,
+		RateEffectiveAt: r.EffectiveAt,
+	}, nil
+}
+
+// ConvertPrices sets the converted prices of the product and its variants with the rate.
+func (p *Product) ConvertPrices(rate *ExchangeRate) error {
+	var err error
+
+	p.ConvertedPrice, err = rate.Convert(p.Price)
+	if err != nil {
+		return err
+	}
+
+	for _, variant := range p.Variants {
+		if variant.Price == nil {
+			continue
+		}
+
+		variant.ConvertedPrice, err = rate.Convert(*variant.Price)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m CurrencyModel) Insert(currency *Currency) error {
+	query := `
+		INSERT INTO currencies (code, name, decimals)
+		VALUES ($1, $2, $3)
+		RETURNING created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, currency.Code, currency.Name, currency.Decimals).Scan(&currency.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "currencies_pkey"`:
+			return ErrDuplicateCurrency
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// currencyRateSQL selects a currency with the rate in effect at the time given by the $1
+// parameter.
+const currencyRateSQL = `
+		SELECT currencies.code, currencies.name, currencies.decimals, currencies.created_at,
+			rates.id, rates.rate, rates.effective_at, rates.created_at
+		FROM currencies
+		LEFT JOIN LATERAL (
+			SELECT id, rate, effective_at, created_at
+			FROM exchange_rates
+			WHERE exchange_rates.currency = currencies.code AND exchange_rates.effective_at <= $1
+			ORDER BY effective_at DESC, id DESC
+			LIMIT 1
+		) rates ON true`
+
+// Get returns the currency with the given code and the rate in effect at the given time.
+func (m CurrencyModel) Get(code string, at time.Time) (*Currency, error) {
+	query := currencyRateSQL + `
+		WHERE currencies.code = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	currency, err := scanCurrency(m.DB.QueryRowContext(ctx, query, at, code))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return currency, nil
+}
+
+// GetAll returns every currency with the rate in effect now.
+func (m CurrencyModel) GetAll() ([]*Currency, error) {
+	query := currencyRateSQL + `
+		ORDER BY currencies.code`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	currencies := []*Currency{}
+
+	for rows.Next() {
+		currency, err := scanCurrency(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		currencies = append(currencies, currency)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return currencies, nil
+}
+
+// RateAt returns the rate of the currency in effect at the given time. ErrRecordNotFound is
+// returned if the currency doesn't exist or didn't have a rate yet.
+func (m CurrencyModel) RateAt(code string, at time.Time) (*ExchangeRate, error) {
+	currency, err := m.Get(code, at)
+	if err != nil {
+		return nil, err
+	}
+
+	if currency.Rate == nil {
+		return nil, ErrRecordNotFound
+	}
+
+	return currency.Rate, nil
+}
+
+// InsertRate adds a rate to the history of its currency.
+func (m CurrencyModel) InsertRate(rate *ExchangeRate) error {
+	query := `
+		INSERT INTO exchange_rates (currency, rate, effective_at)
+		VALUES ($1, $2, $3)
+		RETURNING id, rate, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, rate.Currency, rate.Rate, rate.EffectiveAt).Scan(&rate.ID, &rate.Rate, &rate.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	rate.Rate = trimRate(rate.Rate)
+
+	return nil
+}
+
+// GetRates returns a page of the rate history of a currency, newest first.
+func (m CurrencyModel) GetRates(code string, filters Filters) ([]*ExchangeRate, Metadata, error) {
+	query := `
+		SELECT count(*) OVER(), id, currency, rate, effective_at, created_at
+		FROM exchange_rates
+		WHERE currency = $1
+		ORDER BY effective_at DESC, id DESC
+		LIMIT $2 OFFSET $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, code, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	totalRecords := 0
+	rates := []*ExchangeRate{}
+
+	for rows.Next() {
+		var rate ExchangeRate
+
+		err := rows.Scan(&totalRecords, &rate.ID, &rate.Currency, &rate.Rate, &rate.EffectiveAt, &rate.CreatedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		rate.Rate = trimRate(rate.Rate)
+		rates = append(rates, &rate)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return rates, metadata, nil
+}
+
+func scanCurrency(row rowScanner) (*Currency, error) {
+	var currency Currency
+	var (
+		id          sql.NullInt64
+		rate        sql.NullString
+		effectiveAt sql.NullTime
+		createdAt   sql.NullTime
+	)
+
+	err := row.Scan(&currency.Code, &currency.Name, &currency.Decimals, &currency.CreatedAt, &id, &rate, &effectiveAt, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if id.Valid {
+		currency.Rate = &ExchangeRate{
+			ID:          id.Int64,
+			Currency:    currency.Code,
+			Rate:        trimRate(rate.String),
+			EffectiveAt: effectiveAt.Time,
+			CreatedAt:   createdAt.Time,
+			Decimals:    currency.Decimals,
+		}
+	}
+
+	return &currency, nil
+}
+
+// trimRate removes the trailing zeros the numeric rate column pads rates with.
+func trimRate(rate string) string {
+	if !strings.Contains(rate, ".") {
+		return rate
+	}
+
+	return strings.TrimSuffix(strings.TrimRight(rate, "0"), ".")
+}
@@ -1,6 +1,9 @@
 package filler
 
-import "github.com/ainelnazaraly/CraftShop/pkg/craftshop/model"
+import (
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/model"
+	"github.com/ainelnazaraly/CraftShop/pkg/money"
+)
 
 func PopulateDatabase(models model.Models) error {
 	for _, product := range products {
@@ -13,8 +16,8 @@ func PopulateDatabase(models model.Models) error {
 }
 
 var products = []model.Product{
-	{Name: "Organic Cotton T-Shirt", Description: "Sustainably sourced cotton t-shirt", Price: 25.99, Category: "Clothing", MaterialsUsed: "Organic Cotton", ShippingDetails: "Standard Shipping", SellerID: 4},
-	{Name: "Handmade Ceramic Plant Pot", Description: "Artisan crafted ceramic plant pot", Price: 19.99, Category: "Home Decor", MaterialsUsed: "Ceramic", ShippingDetails: "Free Shipping", SellerID: 3},
+	{Name: "Organic Cotton T-Shirt", Description: "Sustainably sourced cotton t-shirt", Price: money.New(2599, money.DefaultCurrency), Category: "Clothing", MaterialsUsed: "Organic Cotton", ShippingNote: "Standard Shipping", SellerID: 4, Stock: 20},
+	{Name: "Handmade Ceramic Plant Pot", Description: "Artisan crafted ceramic plant pot", Price: money.New(1999, money.DefaultCurrency), Category: "Home Decor", MaterialsUsed: "Ceramic", ShippingNote: "Free Shipping", SellerID: 3, Stock: 5},
 	// Add more products here
 }
 
@@ -2,6 +2,7 @@ package model
 
 import (
 	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/validator"
+	"github.com/ainelnazaraly/CraftShop/pkg/money"
 	"math"
 	"strings"
 )
@@ -11,15 +12,47 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafeList []string
+
+	// The fields below narrow down product listings. Their zero values mean that the listing
+	// isn't filtered by the field; they are ignored by the other listings.
+	Category  string
+	Search    string
+	MinStock  int
+	MinPrice  money.Money
+	MaxPrice  money.Money
+	Materials string
+	Location  string
+	MinRating float64
 }
 
-// Metadata holds pagination metadata.
+// Metadata holds pagination metadata, and for product listings the facets of the result set.
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int     `json:"current_page,omitempty"`
+	PageSize     int     `json:"page_size,omitempty"`
+	FirstPage    int     `json:"first_page,omitempty"`
+	LastPage     int     `json:"last_page,omitempty"`
+	TotalRecords int     `json:"total_records,omitempty"`
+	Facets       *Facets `json:"facets,omitempty"`
+}
+
+// Facets holds the number of records per category and per price range of the whole filtered
+// result set, not just the current page.
+type Facets struct {
+	Categories  []*FacetCount  `json:"categories"`
+	PriceRanges []*PriceBucket `json:"price_ranges"`
+}
+
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// PriceBucket counts the records whose price is at least Min and less than Max. The last bucket
+// has no upper bound, and its Max is nil.
+type PriceBucket struct {
+	Min   money.Money  `json:"min"`
+	Max   *money.Money `json:"max,omitempty"`
+	Count int          `json:"count"`
 }
 
 // calculateMetadata calculates the appropriate pagination metadata values given the total number
@@ -50,6 +83,14 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 
 	// Check that the sort parameter matches a value in the safelist.
 	v.Check(validator.In(f.Sort, f.SortSafeList...), "sort", "invalid sort value")
+
+	// Check that the product listing filters contain sensible values.
+	v.Check(len(f.Search) <= 200, "q", "must not be more than 200 bytes long")
+	v.Check(f.MinStock >= 0, "min_stock", "must not be negative")
+	v.Check(f.MinPrice.Amount >= 0, "min_price", "must not be negative")
+	v.Check(f.MaxPrice.Amount >= 0, "max_price", "must not be negative")
+	v.Check(f.MaxPrice.Amount == 0 || f.MinPrice.Amount <= f.MaxPrice.Amount, "max_price", "must not be less than min_price")
+	v.Check(f.MinRating >= 0 && f.MinRating <= 5, "min_rating", "must be between 0 and 5")
 }
 
 // sortColumn checks that the client-provided Sort field matches one of the entries in our
@@ -0,0 +1,242 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ThumbnailSize describes one of the thumbnails generated for every uploaded product image.
+type ThumbnailSize struct {
+	Name    string
+	MaxSide int
+}
+
+// ThumbnailSizes lists the generated thumbnails from the largest to the smallest, so that each
+// thumbnail can be scaled down from the previous one.
+var ThumbnailSizes = []ThumbnailSize{
+	{Name: "large", MaxSide: 1200},
+	{Name: "medium", MaxSide: 600},
+	{Name: "small", MaxSide: 200},
+}
+
+// MaxImagePixels is the largest number of pixels an uploaded image may have. Images are checked
+// against it before they are decoded, since a small file can declare a huge image.
+const MaxImagePixels = 40_000_000
+
+// imageExtensions maps the accepted image content types to the extension of the stored original.
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// ProductImage is a picture of a product. The files themselves live in the storage under
+// StorageKey; URL and Thumbnails are filled in by the application from the storage.
+type ProductImage struct {
+	ID          int64             `json:"id"`
+	ProductID   int               `json:"product_id"`
+	Position    int               `json:"position"`
+	StorageKey  string            `json:"-"`
+	ContentType string            `json:"content_type"`
+	Size        int64             `json:"size"`
+	Width       int               `json:"width"`
+	Height      int               `json:"height"`
+	URL         string            `json:"url"`
+	Thumbnails  map[string]string `json:"thumbnails"`
+	CreatedAt   time.Time         `json:"created_at"`
+}
+
+// AllowedImageType reports whether images with the given content type can be uploaded.
+func AllowedImageType(contentType string) bool {
+	_, ok := imageExtensions[contentType]
+	return ok
+}
+
+// OriginalKey returns the storage key of the uploaded file.
+func (i *ProductImage) OriginalKey() string {
+	return i.StorageKey + "/original" + imageExtensions[i.ContentType]
+}
+
+// ThumbnailKey returns the storage key of the named thumbnail. Thumbnails are always JPEG.
+func (i *ProductImage) ThumbnailKey(name string) string {
+	return i.StorageKey + "/" + name + ".jpg"
+}
+
+// Keys returns the storage keys of the original and every thumbnail.
+func (i *ProductImage) Keys() []string {
+	keys := []string{i.OriginalKey()}
+	for _, size := range ThumbnailSizes {
+		keys = append(keys, i.ThumbnailKey(size.Name))
+	}
+	return keys
+}
+
+type ProductImageModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Insert adds an image after the last image of the product.
+func (m ProductImageModel) Insert(image *ProductImage) error {
+	query := `
+		INSERT INTO product_images (product_id, position, storage_key, content_type, size_bytes, width, height)
+		VALUES ($1, (SELECT COALESCE(MAX(position) + 1, 0) FROM product_images WHERE product_id = $1), $2, $3, $4, $5, $6)
+		RETURNING id, position, created_at`
+
+	args := []interface{}{image.ProductID, image.StorageKey, image.ContentType, image.Size, image.Width, image.Height}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&image.ID, &image.Position, &image.CreatedAt)
+}
+
+// Get returns an image of the given product.
+func (m ProductImageModel) Get(productID int, id int64) (*ProductImage, error) {
+	query := `
+		SELECT id, product_id, position, storage_key, content_type, size_bytes, width, height, created_at
+		FROM product_images
+		WHERE id = $1 AND product_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var image ProductImage
+
+	err := m.DB.QueryRowContext(ctx, query, id, productID).Scan(
+		&image.ID,
+		&image.ProductID,
+		&image.Position,
+		&image.StorageKey,
+		&image.ContentType,
+		&image.Size,
+		&image.Width,
+		&image.Height,
+		&image.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &image, nil
+}
+
+// GetAllForProducts returns the images of the given products in display order, grouped by
+// product id.
+func (m ProductImageModel) GetAllForProducts(productIDs []int) (map[int][]*ProductImage, error) {
+	query := `
+		SELECT id, product_id, position, storage_key, content_type, size_bytes, width, height, created_at
+		FROM product_images
+		WHERE product_id = ANY($1)
+		ORDER BY product_id, position, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(productIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	images := make(map[int][]*ProductImage, len(productIDs))
+
+	for rows.Next() {
+		var image ProductImage
+
+		err := rows.Scan(
+			&image.ID,
+			&image.ProductID,
+			&image.Position,
+			&image.StorageKey,
+			&image.ContentType,
+			&image.Size,
+			&image.Width,
+			&image.Height,
+			&image.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		images[image.ProductID] = append(images[image.ProductID], &image)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// Reorder sets the display order of the images of a product to the order of ids. The caller
+// must pass every image of the product exactly once.
+func (m ProductImageModel) Reorder(productID int, ids []int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for position, id := range ids {
+		result, err := tx.ExecContext(ctx, `UPDATE product_images SET position = $1 WHERE id = $2 AND product_id = $3`, position, id, productID)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected == 0 {
+			return ErrRecordNotFound
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes an image record. The stored files have to be removed by the caller.
+func (m ProductImageModel) Delete(id int64) error {
+	query := `
+		DELETE FROM product_images
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,268 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ainelnazaraly/CraftShop/pkg/ledger"
+)
+
+// SellerBalance is what the marketplace currently owes a seller (Available) and what has been paid
+// out to them so far.
+type SellerBalance struct {
+	SellerID  int    `json:"seller_id"`
+	Available int64  `json:"available"`
+	PaidOut   int64  `json:"paid_out"`
+	Currency  string `json:"currency"`
+}
+
+// SellerStatement lists the changes of the balance of a seller in a period.
+type SellerStatement struct {
+	SellerID int                     `json:"seller_id"`
+	From     time.Time               `json:"from"`
+	To       time.Time               `json:"to"`
+	Opening  int64                   `json:"opening_balance"`
+	Closing  int64                   `json:"closing_balance"`
+	Currency string                  `json:"currency"`
+	Lines    []*ledger.StatementLine `json:"lines"`
+}
+
+// Payout is the amount paid out to a seller in a payout batch.
+type Payout struct {
+	SellerID int   `json:"seller_id"`
+	Amount   int64 `json:"amount"`
+}
+
+type PayoutBatch struct {
+	ID        int64     `json:"id"`
+	Payouts   []*Payout `json:"payouts"`
+	Total     int64     `json:"total"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type LedgerModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func (m LedgerModel) SellerBalance(sellerID int) (*SellerBalance, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	balance := &SellerBalance{
+		SellerID: sellerID,
+		Currency: DefaultCurrency,
+	}
+
+	var err error
+
+	balance.Available, err = ledger.Balance(ctx, m.DB, ledger.SellerPayable(sellerID))
+	if err != nil {
+		return nil, err
+	}
+
+	balance.PaidOut, err = ledger.Balance(ctx, m.DB, ledger.SellerPaidOut(sellerID))
+	if err != nil {
+		return nil, err
+	}
+
+	return balance, nil
+}
+
+// SellerStatement returns the changes of what the marketplace owes a seller in [from, to).
+func (m LedgerModel) SellerStatement(sellerID int, from, to time.Time) (*SellerStatement, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	opening, lines, err := ledger.Statement(ctx, m.DB, ledger.SellerPayable(sellerID), from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	statement := &SellerStatement{
+		SellerID: sellerID,
+		From:     from,
+		To:       to,
+		Opening:  opening,
+		Closing:  opening,
+		Currency: DefaultCurrency,
+		Lines:    lines,
+	}
+
+	if len(lines) > 0 {
+		statement.Closing = lines[len(lines)-1].Balance
+	}
+
+	return statement, nil
+}
+
+// PayOut moves the balance of every seller who is owed at least minAmount to their paid out
+// account, and records the payouts as a batch. Only one batch runs at a time.
+func (m LedgerModel) PayOut(minAmount int64) (*PayoutBatch, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('payout_batches'))`)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := &PayoutBatch{Payouts: []*Payout{}}
+
+	err = tx.QueryRowContext(ctx, `INSERT INTO payout_batches DEFAULT VALUES RETURNING id, created_at`).Scan(&batch.ID, &batch.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT account, SUM(amount)
+		FROM journal_lines
+		WHERE account LIKE 'seller:%:payable'
+		GROUP BY account
+		HAVING SUM(amount) >= $1 AND SUM(amount) > 0
+		ORDER BY account`
+
+	rows, err := tx.QueryContext(ctx, query, minAmount)
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var account string
+		var payout Payout
+
+		err := rows.Scan(&account, &payout.Amount)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		_, err = fmt.Sscanf(account, "seller:%d:payable", &payout.SellerID)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("invalid seller account %q: %w", account, err)
+		}
+
+		batch.Payouts = append(batch.Payouts, &payout)
+	}
+
+	// The rows have to be closed before the transaction can be used for the postings.
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, payout := range batch.Payouts {
+		entry := &ledger.Entry{
+			Reference:   fmt.Sprintf("payout:%d:seller:%d", batch.ID, payout.SellerID),
+			Description: fmt.Sprintf("Payout batch %d", batch.ID),
+			Currency:    DefaultCurrency,
+			Lines: []ledger.Line{
+				{Account: ledger.SellerPayable(payout.SellerID), Amount: -payout.Amount},
+				{Account: ledger.SellerPaidOut(payout.SellerID), Amount: payout.Amount},
+			},
+		}
+
+		err := ledger.Post(ctx, tx, entry)
+		if err != nil {
+			return nil, err
+		}
+
+		batch.Total += payout.Amount
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE payout_batches SET seller_count = $1, total = $2 WHERE id = $3`,
+		len(batch.Payouts), batch.Total, batch.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return batch, tx.Commit()
+}
+
+// commission returns the marketplace commission of commissionBPS basis points (hundredths of a
+// percent) on amount, rounded to the nearest minor unit.
+func commission(amount int64, commissionBPS int) int64 {
+	return (amount*int64(commissionBPS) + 5_000) / 10_000
+}
+
+func saleReference(purchaseID int64) string {
+	return fmt.Sprintf("sale:purchase:%d", purchaseID)
+}
+
+func refundReference(purchaseID int64) string {
+	return fmt.Sprintf("refund:purchase:%d", purchaseID)
+}
+
+// postPurchaseSale credits the seller of a purchase with its amount minus the marketplace
+// commission. Purchases whose seller has been deleted are not posted, and posting a purchase
+// twice does nothing.
+func postPurchaseSale(ctx context.Context, tx *sql.Tx, purchaseID int64, commissionBPS int) error {
+	var sellerID sql.NullInt64
+	var amount int64
+	var currency string
+
+	err := tx.QueryRowContext(ctx, `SELECT seller_id, amount, currency FROM purchases WHERE id = $1`, purchaseID).Scan(
+		&sellerID, &amount, &currency)
+	if err != nil {
+		return err
+	}
+
+	if !sellerID.Valid {
+		return nil
+	}
+
+	fee := commission(amount, commissionBPS)
+
+	entry := &ledger.Entry{
+		Reference:   saleReference(purchaseID),
+		Description: fmt.Sprintf("Sale of purchase %d", purchaseID),
+		Currency:    currency,
+		Lines: []ledger.Line{
+			{Account: ledger.AccountClearing, Amount: -amount},
+			{Account: ledger.SellerPayable(int(sellerID.Int64)), Amount: amount - fee},
+			{Account: ledger.AccountCommission, Amount: fee},
+		},
+	}
+
+	err = ledger.Post(ctx, tx, entry)
+	if err != nil && !errors.Is(err, ledger.ErrDuplicateEntry) {
+		return err
+	}
+
+	return nil
+}
+
+// reversePurchaseSale debits the seller of a refunded purchase by reversing the sale entry, which
+// returns the commission as well. Reversing a purchase twice, or one which was never posted,
+// does nothing.
+func reversePurchaseSale(ctx context.Context, tx *sql.Tx, purchaseID int64) error {
+	err := ledger.Reverse(ctx, tx, saleReference(purchaseID), refundReference(purchaseID),
+		fmt.Sprintf("Refund of purchase %d", purchaseID))
+	if err != nil && !errors.Is(err, ledger.ErrDuplicateEntry) && !errors.Is(err, ledger.ErrEntryNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+// purchaseSaleReversed reports whether the sale of a purchase has been reversed, e.g. because its
+// order was cancelled or refunded.
+func purchaseSaleReversed(ctx context.Context, tx *sql.Tx, purchaseID int64) (bool, error) {
+	var reversed bool
+
+	err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM journal_entries WHERE reference = $1)`,
+		refundReference(purchaseID)).Scan(&reversed)
+	return reversed, err
+}
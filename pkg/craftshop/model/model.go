@@ -13,6 +13,21 @@ type Models struct {
 	Users 		UserModel
 	Tokens 		TokenModel
 	Permissions	PermissionModel
+	Carts		CartModel
+	Orders		OrderModel
+	Reservations	ReservationModel
+	Reviews		ReviewModel
+	ProductImages	ProductImageModel
+	Payments	PaymentModel
+	Purchases	PurchaseModel
+	Returns		ReturnModel
+	Ledger		LedgerModel
+	Coupons		CouponModel
+	ShippingProfiles	ShippingProfileModel
+	TaxRules	TaxRuleModel
+	Currencies	CurrencyModel
+	Wishlists	WishlistModel
+	Questions	QuestionModel
 }
 
 var (
@@ -21,6 +36,10 @@ var (
 
 	// ErrEditConflict is returned when a there is a data race, and we have an edit conflict.
 	ErrEditConflict = errors.New("edit conflict")
+
+	// ErrInvalidTransition is returned when a record is moved to a state which isn't reachable
+	// from its current state.
+	ErrInvalidTransition = errors.New("invalid state transition")
 )
 
 func NewModel(db *sql.DB) Models { 
@@ -52,5 +71,80 @@ func NewModel(db *sql.DB) Models {
 			InfoLog:  infoLog,
 			ErrorLog: errorLog,
 		},
+		Carts: CartModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Orders: OrderModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Reservations: ReservationModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Reviews: ReviewModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		ProductImages: ProductImageModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Payments: PaymentModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Purchases: PurchaseModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Returns: ReturnModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Ledger: LedgerModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Coupons: CouponModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		ShippingProfiles: ShippingProfileModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		TaxRules: TaxRuleModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Currencies: CurrencyModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Wishlists: WishlistModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Questions: QuestionModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
 	}
 }
\ No newline at end of file
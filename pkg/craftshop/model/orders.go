@@ -0,0 +1,547 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/validator"
+	"github.com/ainelnazaraly/CraftShop/pkg/money"
+	"github.com/lib/pq"
+)
+
+const (
+	OrderStatusPending   = "pending"
+	OrderStatusPaid      = "paid"
+	OrderStatusShipped   = "shipped"
+	OrderStatusDelivered = "delivered"
+	OrderStatusCancelled = "cancelled"
+	OrderStatusRefunded  = "refunded"
+)
+
+// orderTransitions lists, for every order status, the statuses an order may move to next.
+// Cancelled and refunded are final.
+var orderTransitions = map[string][]string{
+	OrderStatusPending:   {OrderStatusPaid, OrderStatusCancelled},
+	OrderStatusPaid:      {OrderStatusShipped, OrderStatusCancelled, OrderStatusRefunded},
+	OrderStatusShipped:   {OrderStatusDelivered},
+	OrderStatusDelivered: {OrderStatusRefunded},
+	OrderStatusCancelled: {},
+	OrderStatusRefunded:  {},
+}
+
+// Order is a placed order. Total is what the buyer pays, after the Discount of the coupon with
+// CouponCode, if one was used.
+type Order struct {
+	ID         int64                `json:"id"`
+	UserID     int64                `json:"user_id"`
+	Status     string               `json:"status"`
+	CouponCode string               `json:"coupon_code,omitempty"`
+	Discount   money.Money          `json:"discount"`
+	Total      money.Money          `json:"total"`
+	Items      []*OrderItem         `json:"items"`
+	History    []*OrderStatusChange `json:"history,omitempty"`
+	CreatedAt  time.Time            `json:"created_at"`
+	UpdatedAt  time.Time            `json:"updated_at"`
+	Version    int                  `json:"-"`
+}
+
+// OrderItem is a snapshot of a product at the time the order was placed. ProductID and SellerID
+// are zero when the product or seller has been deleted since. Discount is the part of the discount
+// of the order allocated to the item.
+type OrderItem struct {
+	ID        int64       `json:"id"`
+	OrderID   int64       `json:"-"`
+	ProductID int         `json:"product_id,omitempty"`
+	SellerID  int         `json:"seller_id,omitempty"`
+	Name      string      `json:"product_name"`
+	UnitPrice money.Money `json:"unit_price"`
+	Quantity  int         `json:"quantity"`
+	Subtotal  money.Money `json:"subtotal"`
+	Discount  money.Money `json:"discount"`
+}
+
+type OrderStatusChange struct {
+	FromStatus string    `json:"from_status,omitempty"`
+	ToStatus   string    `json:"to_status"`
+	ChangedBy  int64     `json:"changed_by,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type OrderModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// CanTransition reports whether the order may be moved from its current status to status.
+func (o *Order) CanTransition(status string) bool {
+	return validator.In(status, orderTransitions[o.Status]...)
+}
+
+// ValidOrderStatus reports whether status is one of the known order statuses.
+func ValidOrderStatus(status string) bool {
+	_, ok := orderTransitions[status]
+	return ok
+}
+
+func ValidateOrder(v *validator.Validator, order *Order) {
+	v.Check(len(order.Items) > 0, "items", "must contain at least one item")
+	v.Check(len(order.Items) <= 100, "items", "must not contain more than 100 items")
+
+	seen := make(map[int]bool, len(order.Items))
+
+	for i, item := range order.Items {
+		v.Check(item.ProductID > 0, fmt.Sprintf("items[%d].product_id", i), "must be provided")
+		v.Check(item.Quantity > 0, fmt.Sprintf("items[%d].quantity", i), "must be greater than zero")
+		v.Check(item.Quantity <= 1000, fmt.Sprintf("items[%d].quantity", i), "must not be more than 1000")
+		v.Check(!seen[item.ProductID], fmt.Sprintf("items[%d].product_id", i), "must not be duplicated")
+		seen[item.ProductID] = true
+	}
+}
+
+// Insert places a new pending order. Every item only needs ProductID and Quantity set; the
+// product name, price and seller are copied from the products table and the stock is decremented
+// inside the same transaction as the insert, consuming the ordered quantity from the reservations
+// the buyer holds for the products. If CouponCode is set the coupon is redeemed and its discount
+// is taken off the total. If one of the products doesn't exist ErrRecordNotFound is returned, if
+// one of them doesn't have enough stock ErrInsufficientStock is returned and if the coupon can't
+// be used an error wrapping ErrCouponNotApplicable is returned; in all cases nothing is written.
+func (m OrderModel) Insert(order *Order) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	order.Status = OrderStatusPending
+	order.Discount = money.New(0, money.DefaultCurrency)
+	order.Total = money.New(0, money.DefaultCurrency)
+
+	// Lock the products in a consistent order, so that two orders for the same products can't
+	// deadlock.
+	sort.SliceStable(order.Items, func(i, j int) bool {
+		return order.Items[i].ProductID < order.Items[j].ProductID
+	})
+
+	couponItems := make([]*CouponItem, len(order.Items))
+
+	for i, item := range order.Items {
+		query := `
+			SELECT product_name, price, seller_id, category
+			FROM products
+			WHERE product_id = $1
+			FOR UPDATE`
+
+		couponItem := &CouponItem{ProductID: item.ProductID, Quantity: item.Quantity}
+
+		err := tx.QueryRowContext(ctx, query, item.ProductID).Scan(&item.Name, &item.UnitPrice, &item.SellerID, &couponItem.Category)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		_, err = decrementStock(ctx, tx, item.ProductID, item.Quantity, order.UserID)
+		if err != nil {
+			return err
+		}
+
+		err = consumeReservations(ctx, tx, item.ProductID, order.UserID, item.Quantity)
+		if err != nil {
+			return err
+		}
+
+		item.Subtotal = item.UnitPrice.Mul(item.Quantity)
+		order.Total = order.Total.Add(item.Subtotal)
+
+		couponItem.SellerID = item.SellerID
+		couponItem.Subtotal = item.Subtotal.Amount
+		couponItems[i] = couponItem
+	}
+
+	var quote *CouponQuote
+
+	if order.CouponCode != "" {
+		quote, err = quoteCoupon(ctx, tx, order.CouponCode, order.UserID, couponItems, true)
+		if err != nil {
+			return err
+		}
+
+		order.CouponCode = quote.Code
+		order.Discount = money.New(quote.Discount, order.Total.Currency)
+
+		for i, item := range order.Items {
+			item.Discount = money.New(couponItems[i].Discount, item.UnitPrice.Currency)
+		}
+	}
+
+	order.Total = order.Total.Sub(order.Discount)
+
+	query := `
+		INSERT INTO orders (user_id, status, coupon_code, discount, total)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, updated_at, version`
+
+	err = tx.QueryRowContext(ctx, query, order.UserID, order.Status, order.CouponCode, order.Discount, order.Total).Scan(
+		&order.ID, &order.CreatedAt, &order.UpdatedAt, &order.Version,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range order.Items {
+		query := `
+			INSERT INTO order_items (order_id, product_id, seller_id, product_name, unit_price, quantity, discount)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING id`
+
+		args := []interface{}{order.ID, item.ProductID, item.SellerID, item.Name, item.UnitPrice, item.Quantity, item.Discount}
+
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&item.ID)
+		if err != nil {
+			return err
+		}
+		item.OrderID = order.ID
+	}
+
+	if quote != nil {
+		err = insertCouponRedemption(ctx, tx, quote, order.UserID, order.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = insertOrderStatusChange(ctx, tx, order.ID, "", order.Status, order.UserID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Get returns the order with the given id together with its items and status history.
+func (m OrderModel) Get(id int64) (*Order, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM orders
+		WHERE id = $1`, orderColumns)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var order Order
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&order.ID, &order.UserID, &order.Status, &order.CouponCode, &order.Discount, &order.Total, &order.CreatedAt,
+		&order.UpdatedAt, &order.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = m.attachItems(ctx, []*Order{&order})
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT COALESCE(from_status, ''), to_status, COALESCE(changed_by, 0), created_at
+		FROM order_status_history
+		WHERE order_id = $1
+		ORDER BY created_at, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, order.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	for rows.Next() {
+		var change OrderStatusChange
+
+		err := rows.Scan(&change.FromStatus, &change.ToStatus, &change.ChangedBy, &change.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		order.History = append(order.History, &change)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &order, nil
+}
+
+// GetAllForUser returns a page of the orders placed by the given user, optionally restricted to
+// a single status.
+func (m OrderModel) GetAllForUser(userID int64, status string, filters Filters) ([]*Order, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), %s
+		FROM orders
+		WHERE user_id = $1
+			AND (status = $2 OR $2 = '')
+		ORDER BY %s %s, id
+		LIMIT $3 OFFSET $4`, orderColumns, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{userID, status, filters.limit(), filters.offset()}
+
+	orders, totalRecords, err := m.query(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return orders, metadata, nil
+}
+
+// GetAllForSeller returns a page of the orders which contain products of the given seller,
+// optionally restricted to a single status. Only the items of the seller are attached, so a
+// seller doesn't see what else the buyer ordered from other sellers.
+func (m OrderModel) GetAllForSeller(sellerID int, status string, filters Filters) ([]*Order, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), %s
+		FROM orders
+		WHERE EXISTS (
+				SELECT 1 FROM order_items
+				WHERE order_items.order_id = orders.id AND order_items.seller_id = $1
+			)
+			AND (status = $2 OR $2 = '')
+		ORDER BY %s %s, id
+		LIMIT $3 OFFSET $4`, orderColumns, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{sellerID, status, filters.limit(), filters.offset()}
+
+	orders, totalRecords, err := m.query(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	for _, order := range orders {
+		order.KeepSellerItems(sellerID)
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return orders, metadata, nil
+}
+
+// KeepSellerItems removes the items of other sellers from the order, and reports whether the
+// order contains any items of the given seller.
+func (o *Order) KeepSellerItems(sellerID int) bool {
+	items := []*OrderItem{}
+
+	for _, item := range o.Items {
+		if item.SellerID == sellerID {
+			items = append(items, item)
+		}
+	}
+
+	o.Items = items
+	return len(items) > 0
+}
+
+// UpdateStatus moves the order to a new status and records the change in the status history.
+// Cancelling an order puts its items back into stock. Paying for it records a purchase for every
+// item and posts the sales to the ledger with a commission of commissionBPS basis points, which
+// cancelling or refunding a paid order reverses. It returns ErrInvalidTransition if the new status
+// isn't reachable from the current one and ErrEditConflict if the order was changed concurrently.
+func (m OrderModel) UpdateStatus(order *Order, status string, changedBy int64, commissionBPS int) error {
+	if !order.CanTransition(status) {
+		return ErrInvalidTransition
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE orders
+		SET status = $1, updated_at = NOW(), version = version + 1
+		WHERE id = $2 AND version = $3
+		RETURNING updated_at, version`
+
+	err = tx.QueryRowContext(ctx, query, status, order.ID, order.Version).Scan(&order.UpdatedAt, &order.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if status == OrderStatusCancelled {
+		query := `
+			UPDATE products
+			SET stock = products.stock + order_items.quantity
+			FROM order_items
+			WHERE order_items.order_id = $1 AND products.product_id = order_items.product_id`
+
+		_, err = tx.ExecContext(ctx, query, order.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch status {
+	case OrderStatusPaid:
+		err = insertOrderPurchases(ctx, tx, m.ErrorLog, order.ID, commissionBPS)
+	case OrderStatusCancelled, OrderStatusRefunded:
+		err = reverseOrderPurchases(ctx, tx, m.ErrorLog, order.ID)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = insertOrderStatusChange(ctx, tx, order.ID, order.Status, status, changedBy)
+	if err != nil {
+		return err
+	}
+
+	order.Status = status
+
+	return tx.Commit()
+}
+
+// query runs a listing query whose first column is the window count and whose remaining columns
+// are the order columns, and returns the orders with their items attached.
+func (m OrderModel) query(ctx context.Context, query string, args ...interface{}) ([]*Order, int, error) {
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	totalRecords := 0
+	orders := []*Order{}
+
+	for rows.Next() {
+		var order Order
+
+		err := rows.Scan(
+			&totalRecords,
+			&order.ID,
+			&order.UserID,
+			&order.Status,
+			&order.CouponCode,
+			&order.Discount,
+			&order.Total,
+			&order.CreatedAt,
+			&order.UpdatedAt,
+			&order.Version,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		orders = append(orders, &order)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	err = m.attachItems(ctx, orders)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return orders, totalRecords, nil
+}
+
+// attachItems loads the items of all the given orders with a single query.
+func (m OrderModel) attachItems(ctx context.Context, orders []*Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(orders))
+	byID := make(map[int64]*Order, len(orders))
+
+	for i, order := range orders {
+		ids[i] = order.ID
+		byID[order.ID] = order
+		order.Items = []*OrderItem{}
+	}
+
+	query := `
+		SELECT id, order_id, COALESCE(product_id, 0), COALESCE(seller_id, 0), product_name, unit_price, quantity, discount
+		FROM order_items
+		WHERE order_id = ANY($1)
+		ORDER BY order_id, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	for rows.Next() {
+		var item OrderItem
+
+		err := rows.Scan(&item.ID, &item.OrderID, &item.ProductID, &item.SellerID, &item.Name, &item.UnitPrice, &item.Quantity, &item.Discount)
+		if err != nil {
+			return err
+		}
+
+		item.Subtotal = item.UnitPrice.Mul(item.Quantity)
+
+		order := byID[item.OrderID]
+		order.Items = append(order.Items, &item)
+	}
+
+	return rows.Err()
+}
+
+// orderColumns are the columns of an order read by Get and query.
+const orderColumns = `orders.id, orders.user_id, orders.status, orders.coupon_code,
+	orders.discount, orders.total, orders.created_at, orders.updated_at, orders.version`
+
+func insertOrderStatusChange(ctx context.Context, tx *sql.Tx, orderID int64, from, to string, changedBy int64) error {
+	query := `
+		INSERT INTO order_status_history (order_id, from_status, to_status, changed_by)
+		VALUES ($1, NULLIF($2, ''), $3, NULLIF($4::bigint, 0))`
+
+	_, err := tx.ExecContext(ctx, query, orderID, from, to, changedBy)
+	return err
+}
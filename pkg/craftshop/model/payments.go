@@ -0,0 +1,359 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/validator"
+	"github.com/ainelnazaraly/CraftShop/pkg/money"
+	"github.com/ainelnazaraly/CraftShop/pkg/payments"
+)
+
+const (
+	PaymentStatusPending    = "pending"
+	PaymentStatusAuthorized = "authorized"
+	PaymentStatusCaptured   = "captured"
+	PaymentStatusFailed     = "failed"
+	PaymentStatusRefunded   = "refunded"
+)
+
+// DefaultCurrency is the currency of all product prices.
+const DefaultCurrency = money.DefaultCurrency
+
+// paymentTransitions lists, for every payment status, the statuses a payment may move to next.
+// Failed and refunded are final.
+var paymentTransitions = map[string][]string{
+	PaymentStatusPending:    {PaymentStatusAuthorized, PaymentStatusFailed},
+	PaymentStatusAuthorized: {PaymentStatusCaptured, PaymentStatusFailed},
+	PaymentStatusCaptured:   {PaymentStatusRefunded},
+	PaymentStatusFailed:     {},
+	PaymentStatusRefunded:   {},
+}
+
+// paymentEventStatuses maps the webhook events of the payment gateways to payment statuses.
+var paymentEventStatuses = map[string]string{
+	payments.EventAuthorized: PaymentStatusAuthorized,
+	payments.EventCaptured:   PaymentStatusCaptured,
+	payments.EventFailed:     PaymentStatusFailed,
+	payments.EventRefunded:   PaymentStatusRefunded,
+}
+
+// Payment tracks the payment intent of a buy-now purchase of a product at the payment gateway.
+// Amount is in minor units of Currency. ProductID and SellerID are zero when the product or seller
+// has been deleted since.
+type Payment struct {
+	ID            int64     `json:"id"`
+	UserID        int64     `json:"user_id"`
+	ProductID     int       `json:"product_id,omitempty"`
+	SellerID      int       `json:"seller_id,omitempty"`
+	Quantity      int       `json:"quantity"`
+	Amount        int64     `json:"amount"`
+	Currency      string    `json:"currency"`
+	Provider      string    `json:"provider"`
+	IntentID      string    `json:"-"`
+	Status        string    `json:"status"`
+	FailureReason string    `json:"failure_reason,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Version       int       `json:"-"`
+}
+
+var (
+	// ErrDuplicateEvent is returned when a webhook event has already been applied.
+	ErrDuplicateEvent = errors.New("duplicate event")
+	// ErrEventMismatch is returned when the amount or currency of a webhook event differs from the
+	// payment it belongs to.
+	ErrEventMismatch = errors.New("event doesn't match payment")
+)
+
+type PaymentModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// CanTransition reports whether the payment may be moved from its current status to status.
+func (p *Payment) CanTransition(status string) bool {
+	return validator.In(status, paymentTransitions[p.Status]...)
+}
+
+func ValidatePayment(v *validator.Validator, payment *Payment) {
+	v.Check(payment.Quantity > 0, "quantity", "must be greater than zero")
+	v.Check(payment.Quantity <= 1000, "quantity", "must not be more than 1000")
+	v.Check(payment.Amount > 0, "amount", "must be greater than zero")
+}
+
+// Insert adds a pending payment. The paid quantity is taken from the stock of the product inside
+// the same transaction, consuming it from the reservations the buyer holds for the product like
+// orders do, so that concurrent purchases can't oversell it. It returns ErrInsufficientStock if
+// the product doesn't have enough stock and ErrRecordNotFound if it doesn't exist; in both cases
+// nothing is written.
+func (m PaymentModel) Insert(payment *Payment) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = decrementStock(ctx, tx, payment.ProductID, payment.Quantity, payment.UserID)
+	if err != nil {
+		return err
+	}
+
+	err = consumeReservations(ctx, tx, payment.ProductID, payment.UserID, payment.Quantity)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO payments (user_id, product_id, seller_id, quantity, amount, currency, provider)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, status, created_at, updated_at, version`
+
+	args := []interface{}{payment.UserID, payment.ProductID, payment.SellerID, payment.Quantity, payment.Amount,
+		payment.Currency, payment.Provider}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(
+		&payment.ID, &payment.Status, &payment.CreatedAt, &payment.UpdatedAt, &payment.Version)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m PaymentModel) Get(id int64) (*Payment, error) {
+	query := `
+		SELECT id, user_id, COALESCE(product_id, 0), COALESCE(seller_id, 0), quantity, amount, currency, provider,
+			COALESCE(provider_intent_id, ''), status, failure_reason, created_at, updated_at, version
+		FROM payments
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	payment, err := scanPayment(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return payment, nil
+}
+
+// UpdateStatus moves the payment to a new status. intentID is stored when it isn't empty, and
+// reason is kept for failed payments. The stock taken by a failed payment is put back. It returns
+// ErrInvalidTransition if the new status isn't reachable from the current one and ErrEditConflict
+// if the payment was changed concurrently.
+func (m PaymentModel) UpdateStatus(payment *Payment, status, intentID, reason string) error {
+	if !payment.CanTransition(status) {
+		return ErrInvalidTransition
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE payments
+		SET status = $1, provider_intent_id = COALESCE(NULLIF($2, ''), provider_intent_id), failure_reason = $3,
+			updated_at = NOW(), version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING updated_at, version`
+
+	args := []interface{}{status, intentID, reason, payment.ID, payment.Version}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&payment.UpdatedAt, &payment.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if status == PaymentStatusFailed {
+		err = restockPayment(ctx, tx, payment)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	payment.Status = status
+	payment.FailureReason = reason
+	if intentID != "" {
+		payment.IntentID = intentID
+	}
+
+	return nil
+}
+
+// ApplyEvent advances the payment of a webhook event. Once the payment is captured the purchase
+// is recorded and the sale is posted to the ledger with a commission of commissionBPS basis
+// points; a refund reverses the sale. Every event is recorded, so an event which is delivered
+// again returns ErrDuplicateEvent without changing anything. Events which don't lead to a
+// reachable status, e.g. because they arrive out of order, are recorded but ignored. It returns
+// ErrRecordNotFound if no payment belongs to the intent of the event. Captures and refunds whose
+// amount or currency differ from the payment return ErrEventMismatch and aren't recorded. A
+// failed payment puts its stock back.
+func (m PaymentModel) ApplyEvent(provider string, event *payments.Event, commissionBPS int) (*Payment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT id, user_id, COALESCE(product_id, 0), COALESCE(seller_id, 0), quantity, amount, currency, provider,
+			COALESCE(provider_intent_id, ''), status, failure_reason, created_at, updated_at, version
+		FROM payments
+		WHERE provider = $1 AND provider_intent_id = $2
+		FOR UPDATE`
+
+	payment, err := scanPayment(tx.QueryRowContext(ctx, query, provider, event.IntentID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	status, ok := paymentEventStatuses[event.Type]
+
+	if status == PaymentStatusCaptured || status == PaymentStatusRefunded {
+		if event.Amount != payment.Amount || !strings.EqualFold(event.Currency, payment.Currency) {
+			return payment, ErrEventMismatch
+		}
+	}
+
+	query = `
+		INSERT INTO payment_events (provider, event_id, payment_id, type)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING`
+
+	result, err := tx.ExecContext(ctx, query, provider, event.ID, payment.ID, event.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return payment, ErrDuplicateEvent
+	}
+
+	if ok && payment.CanTransition(status) {
+		query := `
+			UPDATE payments
+			SET status = $1, failure_reason = $2, updated_at = NOW(), version = version + 1
+			WHERE id = $3
+			RETURNING updated_at, version`
+
+		err = tx.QueryRowContext(ctx, query, status, event.Reason, payment.ID).Scan(&payment.UpdatedAt, &payment.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		payment.Status = status
+		payment.FailureReason = event.Reason
+
+		switch status {
+		case PaymentStatusCaptured:
+			err = insertPaymentPurchase(ctx, tx, payment, commissionBPS)
+		case PaymentStatusRefunded:
+			err = reversePaymentPurchase(ctx, tx, payment.ID)
+		case PaymentStatusFailed:
+			err = restockPayment(ctx, tx, payment)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return payment, tx.Commit()
+}
+
+// reversePaymentPurchase reverses the sale of the purchase paid by a refunded payment.
+func reversePaymentPurchase(ctx context.Context, tx *sql.Tx, paymentID int64) error {
+	var purchaseID int64
+
+	err := tx.QueryRowContext(ctx, `SELECT id FROM purchases WHERE payment_id = $1`, paymentID).Scan(&purchaseID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil
+		default:
+			return err
+		}
+	}
+
+	return reversePurchaseSale(ctx, tx, purchaseID)
+}
+
+// restockPayment puts the quantity of a failed payment back into the stock of the product, unless
+// the product has been deleted since.
+func restockPayment(ctx context.Context, tx *sql.Tx, payment *Payment) error {
+	query := `
+		UPDATE products
+		SET stock = stock + $1
+		WHERE product_id = $2`
+
+	_, err := tx.ExecContext(ctx, query, payment.Quantity, payment.ProductID)
+	return err
+}
+
+func scanPayment(row rowScanner) (*Payment, error) {
+	var payment Payment
+
+	err := row.Scan(
+		&payment.ID,
+		&payment.UserID,
+		&payment.ProductID,
+		&payment.SellerID,
+		&payment.Quantity,
+		&payment.Amount,
+		&payment.Currency,
+		&payment.Provider,
+		&payment.IntentID,
+		&payment.Status,
+		&payment.FailureReason,
+		&payment.CreatedAt,
+		&payment.UpdatedAt,
+		&payment.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &payment, nil
+}
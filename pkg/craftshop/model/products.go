@@ -4,24 +4,46 @@ package model
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"time"
 
 	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/validator"
+	"github.com/ainelnazaraly/CraftShop/pkg/money"
+	"github.com/lib/pq"
 )
 
 type Product struct {
-	ID              int     `json:"product_id"`
-	SellerID        int     `json:"seller_id"`
-	Name            string  `json:"product_name"`
-	Description     string  `json:"description"`
-	Price           float64 `json:"price"`
-	Category        string  `json:"category"`
-	MaterialsUsed   string  `json:"materials_used"`
-	ShippingDetails string  `json:"shipping_details"`
+	ID                int               `json:"product_id"`
+	SellerID          int               `json:"seller_id"`
+	Name              string            `json:"product_name"`
+	Description       string            `json:"description"`
+	Price             money.Money       `json:"price"`
+	ConvertedPrice    *ConvertedPrice   `json:"converted_price,omitempty"`
+	Category          string            `json:"category"`
+	MaterialsUsed     string            `json:"materials_used"`
+	ShippingNote      string            `json:"shipping_note"`
+	ShippingProfileID int64             `json:"shipping_profile_id,omitempty"`
+	WeightGrams       int               `json:"weight_grams"`
+	Stock             int               `json:"stock"`
+	RatingAverage     float64           `json:"rating_average"`
+	RatingCount       int               `json:"rating_count"`
+	FavouritedCount   int               `json:"favourited_count"`
+	Images            []*ProductImage   `json:"images"`
+	Options           []*ProductOption  `json:"options,omitempty"`
+	Variants          []*ProductVariant `json:"variants,omitempty"`
 }
 
+// maxPrice is the largest price the DECIMAL(10, 2) price columns hold, in cents.
+const maxPrice = 99999999_99
+
+var (
+	// ErrInsufficientStock is returned when a product doesn't have enough unreserved stock left
+	// to satisfy a decrement or a reservation.
+	ErrInsufficientStock = errors.New("insufficient stock")
+)
+
 type ProductModel struct {
 	DB       *sql.DB
 	InfoLog  *log.Logger
@@ -35,31 +57,58 @@ func ValidateProduct(v *validator.Validator, product *Product) {
 	v.Check(len(product.Name) <= 100, "product_name", "must not be more than 100 characters long")
 	// Check if the description field is empty.
 	v.Check(product.Description != "", "description", "must be provided")
-	// Check if the price field is greater than zero.
-	v.Check(product.Price > 0, "price", "must be greater than zero")
+	// Check if the price field is greater than zero and fits the DECIMAL(10, 2) price column.
+	v.Check(product.Price.Amount > 0, "price", "must be greater than zero")
+	v.Check(product.Price.Amount <= maxPrice, "price", "must not be more than 99999999.99")
+	// Check that the price is in the currency of the shop.
+	v.Check(product.Price.Currency == money.DefaultCurrency, "price", "must be in "+money.DefaultCurrency)
 	// Check if the category field is empty.
 	v.Check(product.Category != "", "category", "must be provided")
 	// Check if the category field is not more than 100 characters.
 	v.Check(len(product.Category) <= 100, "category", "must not be more than 100 characters long")
 	// Check if the materials used field is not more than 255 characters.
 	v.Check(len(product.MaterialsUsed) <= 255, "materials_used", "must not be more than 255 characters long")
-	// Check if the shipping details field is not more than 255 characters.
-	v.Check(len(product.ShippingDetails) <= 255, "shipping_details", "must not be more than 255 characters long")
+	// Check if the shipping note field is not more than 255 characters.
+	v.Check(len(product.ShippingNote) <= 255, "shipping_note", "must not be more than 255 characters long")
+	// Check that the weight, which weight-based shipping rates are charged by, is not negative.
+	v.Check(product.WeightGrams >= 0, "weight_grams", "must not be negative")
+	// Check that the stock level is not negative.
+	v.Check(product.Stock >= 0, "stock", "must not be negative")
+	// Check the options schema and the variants of the product.
+	validateProductVariants(v, product)
 }
 
+// Update saves the details of a product. The stock isn't part of it; it only changes through
+// IncrementStock and DecrementStock, so that units taken at the same time aren't overwritten.
 func (p ProductModel) Update(prod *Product) error {
 	query := `
     UPDATE products 
-    SET product_name = $1, description = $2, price = $3, category = $4, materials_used = $5, shipping_details=$6, seller_id = $7
-    WHERE product_id=$8
+    SET product_name = $1, description = $2, price = $3, category = $4, materials_used = $5, shipping_note=$6, seller_id = $7,
+        shipping_profile_id = NULLIF($8, 0), weight_grams = $9
+    WHERE product_id=$10
     `
-	args := []interface{}{prod.Name, prod.Description, prod.Price, prod.Category, prod.MaterialsUsed, prod.ShippingDetails, prod.SellerID, prod.ID}
+	args := []interface{}{prod.Name, prod.Description, prod.Price, prod.Category, prod.MaterialsUsed, prod.ShippingNote, prod.SellerID,
+		prod.ShippingProfileID, prod.WeightGrams, prod.ID}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := p.DB.ExecContext(ctx, query, args...)
-	return err
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	err = saveProductVariants(ctx, tx, prod)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (p ProductModel) Delete(id int) error {
@@ -77,21 +126,38 @@ func (p ProductModel) Delete(id int) error {
 
 func (p ProductModel) Insert(prod *Product) error {
 	query := `
-		INSERT INTO products (product_name, description, price, category, materials_used, shipping_details, seller_id)
-		VALUES ($1, $2, $3, $4, $5, $6, $7) 
+		INSERT INTO products (product_name, description, price, category, materials_used, shipping_note, seller_id, stock, shipping_profile_id, weight_grams)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, 0), $10) 
 		RETURNING product_id
 		`
-	args := []interface{}{prod.Name, prod.Description, prod.Price, prod.Category, prod.MaterialsUsed, prod.ShippingDetails, prod.SellerID}
+	args := []interface{}{prod.Name, prod.Description, prod.Price, prod.Category, prod.MaterialsUsed, prod.ShippingNote, prod.SellerID, prod.Stock,
+		prod.ShippingProfileID, prod.WeightGrams}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return p.DB.QueryRowContext(ctx, query, args...).Scan(&prod.ID)
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&prod.ID)
+	if err != nil {
+		return err
+	}
+
+	err = saveProductVariants(ctx, tx, prod)
+	if err != nil {
+		return err
+	}
 
+	return tx.Commit()
 }
 
 func (p ProductModel) Get(id int) (*Product, error) {
 	query := `
-    SELECT product_id, product_name, description, price, category, materials_used, shipping_details, seller_id
+    SELECT product_id, product_name, description, price, category, materials_used, shipping_note, COALESCE(shipping_profile_id, 0), weight_grams,
+        seller_id, stock, rating_average, rating_count, favourited_count
     FROM products
     WHERE product_id=$1
     `
@@ -100,25 +166,67 @@ func (p ProductModel) Get(id int) (*Product, error) {
 	defer cancel()
 
 	row := p.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(&prod.ID, &prod.Name, &prod.Description, &prod.Price, &prod.Category, &prod.MaterialsUsed, &prod.ShippingDetails, &prod.SellerID)
+	err := row.Scan(&prod.ID, &prod.Name, &prod.Description, &prod.Price, &prod.Category, &prod.MaterialsUsed, &prod.ShippingNote, &prod.ShippingProfileID, &prod.WeightGrams, &prod.SellerID, &prod.Stock, &prod.RatingAverage, &prod.RatingCount, &prod.FavouritedCount)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = p.loadProductVariants(ctx, &prod)
 	if err != nil {
 		return nil, err
 	}
+
 	return &prod, nil
 }
 
-func (p ProductModel) GetAll(category string, filters Filters) ([]*Product, Metadata, error) {
-	query := fmt.Sprintf(`
-        SELECT count(*) OVER(), product_id, seller_id, product_name, description, price, category, materials_used, shipping_details
+// productFilterSQL is the FROM and WHERE clause shared by the product listing and its facets.
+// Every filter is skipped when its parameter holds the zero value.
+const productFilterSQL = `
         FROM products
-        WHERE category = $1 
+        LEFT JOIN sellers ON sellers.seller_id = products.seller_id
+        WHERE (products.category = $1 OR $1 = '')
+			AND (products.search_vector @@ websearch_to_tsquery('english', $2) OR $2 = '')
+			AND products.stock >= $3
+			AND (products.price >= $4 OR $4 = 0)
+			AND (products.price <= $5 OR $5 = 0)
+			AND (products.materials_used ILIKE '%' || $6 || '%' OR $6 = '')
+			AND (LOWER(sellers.location) = LOWER($7) OR $7 = '')
+			AND products.rating_average >= $8`
+
+// priceBucketBounds are the lower bounds of the price ranges reported in the facets, apart from
+// the first range which starts at zero.
+var priceBucketBounds = []money.Money{
+	money.New(2500, money.DefaultCurrency),
+	money.New(5000, money.DefaultCurrency),
+	money.New(10000, money.DefaultCurrency),
+	money.New(25000, money.DefaultCurrency),
+}
+
+// GetAll returns a page of the products matching the product listing fields of filters, with
+// the facets of the whole result set in the metadata. An empty category matches every category,
+// and a non-empty search restricts the result to products whose name, description or materials
+// match the search terms. The "rank" sort column orders the products by how well they match the
+// search.
+func (p ProductModel) GetAll(filters Filters) ([]*Product, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), products.product_id, products.seller_id, products.product_name, products.description,
+			products.price, products.category, products.materials_used, products.shipping_note,
+			COALESCE(products.shipping_profile_id, 0), products.weight_grams, products.stock,
+			products.rating_average, products.rating_count, products.favourited_count,
+			ts_rank(products.search_vector, websearch_to_tsquery('english', $2)) AS rank
+        %s
         ORDER BY %s %s, product_id
-        LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+        LIMIT $9 OFFSET $10`, productFilterSQL, filters.sortColumn(), filters.sortDirection())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []interface{}{category, filters.limit(), filters.offset()}
+	args := append(productFilterArgs(filters), filters.limit(), filters.offset())
 
 	rows, err := p.DB.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -131,6 +239,7 @@ func (p ProductModel) GetAll(category string, filters Filters) ([]*Product, Meta
 
 	for rows.Next() {
 		var product Product
+		var rank float64
 		err := rows.Scan(
 			&totalRecords,
 			&product.ID,
@@ -140,7 +249,14 @@ func (p ProductModel) GetAll(category string, filters Filters) ([]*Product, Meta
 			&product.Price,
 			&product.Category,
 			&product.MaterialsUsed,
-			&product.ShippingDetails,
+			&product.ShippingNote,
+			&product.ShippingProfileID,
+			&product.WeightGrams,
+			&product.Stock,
+			&product.RatingAverage,
+			&product.RatingCount,
+			&product.FavouritedCount,
+			&rank,
 		)
 		if err != nil {
 			return nil, Metadata{}, err
@@ -152,13 +268,122 @@ func (p ProductModel) GetAll(category string, filters Filters) ([]*Product, Meta
 	}
 
 	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	metadata.Facets, err = p.facets(ctx, filters)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
 	return products, metadata, nil
 }
 
+// facets counts the products matching filters per category and per price range.
+func (p ProductModel) facets(ctx context.Context, filters Filters) (*Facets, error) {
+	facets := &Facets{
+		Categories:  []*FacetCount{},
+		PriceRanges: make([]*PriceBucket, len(priceBucketBounds)+1),
+	}
+
+	query := fmt.Sprintf(`
+        SELECT COALESCE(products.category, ''), count(*)
+        %s
+        GROUP BY 1
+        ORDER BY 2 DESC, 1`, productFilterSQL)
+
+	rows, err := p.DB.QueryContext(ctx, query, productFilterArgs(filters)...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			p.ErrorLog.Println(err)
+		}
+	}()
+
+	for rows.Next() {
+		var facet FacetCount
+
+		if err := rows.Scan(&facet.Value, &facet.Count); err != nil {
+			return nil, err
+		}
+
+		facets.Categories = append(facets.Categories, &facet)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// width_bucket returns 0 for prices below the first bound and len(bounds) for prices at or
+	// above the last one, which lines up with the indexes of facets.PriceRanges.
+	for i := range facets.PriceRanges {
+		bucket := &PriceBucket{Min: money.New(0, money.DefaultCurrency)}
+		if i > 0 {
+			bucket.Min = priceBucketBounds[i-1]
+		}
+		if i < len(priceBucketBounds) {
+			max := priceBucketBounds[i]
+			bucket.Max = &max
+		}
+		facets.PriceRanges[i] = bucket
+	}
+
+	bounds := make([]string, len(priceBucketBounds))
+	for i, bound := range priceBucketBounds {
+		bounds[i] = bound.String()
+	}
+
+	query = fmt.Sprintf(`
+        SELECT width_bucket(products.price, $9::numeric[]), count(*)
+        %s
+        GROUP BY 1`, productFilterSQL)
+
+	args := append(productFilterArgs(filters), pq.Array(bounds))
+
+	bucketRows, err := p.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := bucketRows.Close(); err != nil {
+			p.ErrorLog.Println(err)
+		}
+	}()
+
+	for bucketRows.Next() {
+		var bucket, count int
+
+		if err := bucketRows.Scan(&bucket, &count); err != nil {
+			return nil, err
+		}
+
+		facets.PriceRanges[bucket].Count = count
+	}
+	if err = bucketRows.Err(); err != nil {
+		return nil, err
+	}
+
+	return facets, nil
+}
+
+// productFilterArgs returns the parameters of productFilterSQL in order.
+func productFilterArgs(filters Filters) []interface{} {
+	return []interface{}{
+		filters.Category,
+		filters.Search,
+		filters.MinStock,
+		filters.MinPrice,
+		filters.MaxPrice,
+		filters.Materials,
+		filters.Location,
+		filters.MinRating,
+	}
+}
+
 func (p *ProductModel) GetBySellerID(sellerID int) ([]*Product, error) {
     // Query to retrieve products by seller ID
     query := `
-        SELECT product_id, product_name, description, price, category, materials_used, shipping_details
+        SELECT product_id, product_name, description, price, category, materials_used, shipping_note, COALESCE(shipping_profile_id, 0), weight_grams,
+            stock, rating_average, rating_count, favourited_count
         FROM products
         WHERE seller_id = $1`
 
@@ -173,7 +398,7 @@ func (p *ProductModel) GetBySellerID(sellerID int) ([]*Product, error) {
     var products []*Product
     for rows.Next() {
         var product Product
-        if err := rows.Scan(&product.ID, &product.Name, &product.Description, &product.Price, &product.Category, &product.MaterialsUsed, &product.ShippingDetails); err != nil {
+        if err := rows.Scan(&product.ID, &product.Name, &product.Description, &product.Price, &product.Category, &product.MaterialsUsed, &product.ShippingNote, &product.ShippingProfileID, &product.WeightGrams, &product.Stock, &product.RatingAverage, &product.RatingCount, &product.FavouritedCount); err != nil {
             return nil, err
         }
         products = append(products, &product)
@@ -187,3 +412,90 @@ func (p *ProductModel) GetBySellerID(sellerID int) ([]*Product, error) {
     return products, nil
 }
 
+// DecrementStock atomically removes quantity units from the stock of a product and returns the
+// new stock level. Units held back by active reservations are not available, and
+// ErrInsufficientStock is returned if the stock would otherwise go below them.
+func (p ProductModel) DecrementStock(id, quantity int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stock, err := decrementStock(ctx, tx, id, quantity, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	return stock, tx.Commit()
+}
+
+// IncrementStock atomically adds quantity units to the stock of a product and returns the new
+// stock level.
+func (p ProductModel) IncrementStock(id, quantity int) (int, error) {
+	query := `
+		UPDATE products
+		SET stock = stock + $1
+		WHERE product_id = $2
+		RETURNING stock`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var stock int
+
+	err := p.DB.QueryRowContext(ctx, query, quantity, id).Scan(&stock)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return stock, nil
+}
+
+// decrementStock removes quantity units from the stock of a product inside tx. The product row
+// is locked first, so that concurrent decrements and reservations of the same product are
+// serialized. Reservations held by userID are treated as available to that user; pass 0 to
+// respect every active reservation.
+func decrementStock(ctx context.Context, tx *sql.Tx, productID, quantity int, userID int64) (int, error) {
+	var stock int
+
+	err := tx.QueryRowContext(ctx, `SELECT stock FROM products WHERE product_id = $1 FOR UPDATE`, productID).Scan(&stock)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	reserved, err := reservedStock(ctx, tx, productID, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	if stock-quantity < reserved {
+		return 0, ErrInsufficientStock
+	}
+
+	query := `
+		UPDATE products
+		SET stock = stock - $1
+		WHERE product_id = $2
+		RETURNING stock`
+
+	err = tx.QueryRowContext(ctx, query, quantity, productID).Scan(&stock)
+	if err != nil {
+		return 0, err
+	}
+
+	return stock, nil
+}
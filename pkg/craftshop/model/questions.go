@@ -0,0 +1,259 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/validator"
+)
+
+// Question is a question a user asked about a product, with the answer of the seller once there
+// is one. Hidden and AnswerHidden are set by moderators: a hidden question is left out of the
+// public listing altogether, while a hidden answer is blanked out of it.
+type Question struct {
+	ID           int64      `json:"id"`
+	ProductID    int        `json:"product_id"`
+	UserID       int64      `json:"user_id"`
+	UserName     string     `json:"user_name"`
+	Question     string     `json:"question"`
+	Answer       string     `json:"answer,omitempty"`
+	AnsweredBy   int64      `json:"answered_by,omitempty"`
+	AnsweredAt   *time.Time `json:"answered_at,omitempty"`
+	Hidden       bool       `json:"hidden,omitempty"`
+	AnswerHidden bool       `json:"answer_hidden,omitempty"`
+	ModeratedBy  int64      `json:"moderated_by,omitempty"`
+	ModeratedAt  *time.Time `json:"moderated_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	Version      int        `json:"-"`
+}
+
+// QuestionFilters selects the questions of a product listing. Answered filters by whether the
+// question has an answer when it is not nil, and IncludeHidden is only set for moderators.
+type QuestionFilters struct {
+	Answered      *bool
+	IncludeHidden bool
+	Filters
+}
+
+type QuestionModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateQuestion(v *validator.Validator, question *Question) {
+	v.Check(question.Question != "", "question", "must be provided")
+	v.Check(len(question.Question) <= 1000, "question", "must not be more than 1000 bytes long")
+}
+
+func ValidateAnswer(v *validator.Validator, question *Question) {
+	v.Check(question.Answer != "", "answer", "must be provided")
+	v.Check(len(question.Answer) <= 2000, "answer", "must not be more than 2000 bytes long")
+}
+
+func (m QuestionModel) Insert(question *Question) error {
+	query := `
+		INSERT INTO product_questions (product_id, user_id, question)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, updated_at, version`
+
+	args := []interface{}{question.ProductID, question.UserID, question.Question}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&question.ID, &question.CreatedAt, &question.UpdatedAt, &question.Version)
+}
+
+// questionColumns are the columns read by scanQuestion.
+const questionColumns = `
+		product_questions.id, product_questions.product_id, product_questions.user_id, users.name,
+		product_questions.question, product_questions.answer, COALESCE(product_questions.answered_by, 0),
+		product_questions.answered_at, product_questions.hidden, product_questions.answer_hidden,
+		COALESCE(product_questions.moderated_by, 0), product_questions.moderated_at,
+		product_questions.created_at, product_questions.updated_at, product_questions.version`
+
+func scanQuestion(row rowScanner, dest ...interface{}) (*Question, error) {
+	var question Question
+	var answeredAt, moderatedAt sql.NullTime
+
+	dest = append(dest,
+		&question.ID,
+		&question.ProductID,
+		&question.UserID,
+		&question.UserName,
+		&question.Question,
+		&question.Answer,
+		&question.AnsweredBy,
+		&answeredAt,
+		&question.Hidden,
+		&question.AnswerHidden,
+		&question.ModeratedBy,
+		&moderatedAt,
+		&question.CreatedAt,
+		&question.UpdatedAt,
+		&question.Version,
+	)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	if answeredAt.Valid {
+		question.AnsweredAt = &answeredAt.Time
+	}
+
+	if moderatedAt.Valid {
+		question.ModeratedAt = &moderatedAt.Time
+	}
+
+	return &question, nil
+}
+
+// Get returns a question about the given product, including hidden ones.
+func (m QuestionModel) Get(productID int, id int64) (*Question, error) {
+	query := `SELECT ` + questionColumns + `
+		FROM product_questions
+		INNER JOIN users ON users.id = product_questions.user_id
+		WHERE product_questions.id = $1 AND product_questions.product_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	question, err := scanQuestion(m.DB.QueryRowContext(ctx, query, id, productID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return question, nil
+}
+
+// GetAllForProduct returns a page of the questions about a product. Unless IncludeHidden is set,
+// hidden questions are left out and hidden answers are blanked.
+func (m QuestionModel) GetAllForProduct(productID int, filters QuestionFilters) ([]*Question, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), %s
+		FROM product_questions
+		INNER JOIN users ON users.id = product_questions.user_id
+		WHERE product_questions.product_id = $1
+			AND (NOT product_questions.hidden OR $2)
+			AND ($3::boolean IS NULL OR (product_questions.answer <> '') = $3)
+		ORDER BY product_questions.%s %s NULLS LAST, product_questions.id
+		LIMIT $4 OFFSET $5`, questionColumns, filters.sortColumn(), filters.sortDirection())
+
+	args := []interface{}{productID, filters.IncludeHidden, filters.Answered, filters.limit(), filters.offset()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	totalRecords := 0
+	questions := []*Question{}
+
+	for rows.Next() {
+		question, err := scanQuestion(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		if question.AnswerHidden && !filters.IncludeHidden {
+			question.Answer = ""
+			question.AnsweredBy = 0
+			question.AnsweredAt = nil
+		}
+
+		questions = append(questions, question)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return questions, metadata, nil
+}
+
+// Answer saves the answer of a question given by the user answeredBy. Answering again replaces
+// the previous answer. It returns ErrEditConflict if the question was changed concurrently.
+func (m QuestionModel) Answer(question *Question, answeredBy int64) error {
+	query := `
+		UPDATE product_questions
+		SET answer = $1, answered_by = $2, answered_at = NOW(), updated_at = NOW(), version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING answered_at, updated_at, version`
+
+	args := []interface{}{question.Answer, answeredBy, question.ID, question.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var answeredAt time.Time
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&answeredAt, &question.UpdatedAt, &question.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	question.AnsweredBy = answeredBy
+	question.AnsweredAt = &answeredAt
+
+	return nil
+}
+
+// Moderate saves the Hidden and AnswerHidden flags of a question set by the moderator
+// moderatedBy. It returns ErrEditConflict if the question was changed concurrently.
+func (m QuestionModel) Moderate(question *Question, moderatedBy int64) error {
+	query := `
+		UPDATE product_questions
+		SET hidden = $1, answer_hidden = $2, moderated_by = $3, moderated_at = NOW(), updated_at = NOW(),
+			version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING moderated_at, updated_at, version`
+
+	args := []interface{}{question.Hidden, question.AnswerHidden, moderatedBy, question.ID, question.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var moderatedAt time.Time
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&moderatedAt, &question.UpdatedAt, &question.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	question.ModeratedBy = moderatedBy
+	question.ModeratedAt = &moderatedAt
+
+	return nil
+}
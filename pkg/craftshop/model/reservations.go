@@ -0,0 +1,202 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/validator"
+)
+
+// ReservationTTL is how long a stock reservation holds back units of a product.
+const ReservationTTL = 15 * time.Minute
+
+// StockReservation holds back Quantity units of a product for a single user until ExpiresAt, so
+// that another buyer can't order the same units in the meantime.
+type StockReservation struct {
+	ID        int64     `json:"id"`
+	ProductID int       `json:"product_id"`
+	UserID    int64     `json:"user_id"`
+	Quantity  int       `json:"quantity"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type ReservationModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateReservation(v *validator.Validator, reservation *StockReservation) {
+	v.Check(reservation.Quantity > 0, "quantity", "must be greater than zero")
+	v.Check(reservation.Quantity <= 1000, "quantity", "must not be more than 1000")
+}
+
+// Insert reserves stock for a user. It returns ErrInsufficientStock if the product doesn't have
+// enough stock left which isn't already reserved by someone else, and ErrRecordNotFound if the
+// product doesn't exist.
+func (m ReservationModel) Insert(reservation *StockReservation, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the product row so that reservations and stock decrements of the product are
+	// serialized.
+	var stock int
+
+	err = tx.QueryRowContext(ctx, `SELECT stock FROM products WHERE product_id = $1 FOR UPDATE`, reservation.ProductID).Scan(&stock)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM stock_reservations WHERE product_id = $1 AND expires_at <= NOW()`, reservation.ProductID)
+	if err != nil {
+		return err
+	}
+
+	reserved, err := reservedStock(ctx, tx, reservation.ProductID, 0)
+	if err != nil {
+		return err
+	}
+
+	if stock-reserved < reservation.Quantity {
+		return ErrInsufficientStock
+	}
+
+	query := `
+		INSERT INTO stock_reservations (product_id, user_id, quantity, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, expires_at`
+
+	args := []interface{}{reservation.ProductID, reservation.UserID, reservation.Quantity, time.Now().Add(ttl)}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&reservation.ID, &reservation.CreatedAt, &reservation.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete releases a reservation held by the given user.
+func (m ReservationModel) Delete(id, userID int64) error {
+	query := `
+		DELETE FROM stock_reservations
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// reservedStock returns the number of units of a product held back by active reservations of
+// users other than userID.
+func reservedStock(ctx context.Context, tx *sql.Tx, productID int, userID int64) (int, error) {
+	query := `
+		SELECT COALESCE(SUM(quantity), 0)
+		FROM stock_reservations
+		WHERE product_id = $1
+			AND user_id <> $2
+			AND expires_at > NOW()`
+
+	var reserved int
+
+	err := tx.QueryRowContext(ctx, query, productID, userID).Scan(&reserved)
+	return reserved, err
+}
+
+// consumeReservations releases quantity units of the reservations a user holds for a product,
+// because the user ordered them. Reservations which expire first are consumed first, and a
+// reservation for more units than are left to consume is reduced rather than deleted, so units
+// reserved beyond the ordered quantity stay reserved. The product row must already be locked.
+func consumeReservations(ctx context.Context, tx *sql.Tx, productID int, userID int64, quantity int) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM stock_reservations WHERE product_id = $1 AND user_id = $2 AND expires_at <= NOW()`, productID, userID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		SELECT id, quantity
+		FROM stock_reservations
+		WHERE product_id = $1 AND user_id = $2
+		ORDER BY expires_at, id`
+
+	rows, err := tx.QueryContext(ctx, query, productID, userID)
+	if err != nil {
+		return err
+	}
+
+	type reservation struct {
+		id       int64
+		quantity int
+	}
+
+	var reservations []reservation
+
+	for rows.Next() {
+		var r reservation
+
+		if err := rows.Scan(&r.id, &r.quantity); err != nil {
+			rows.Close()
+			return err
+		}
+
+		reservations = append(reservations, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+
+	if err = rows.Close(); err != nil {
+		return err
+	}
+
+	for _, r := range reservations {
+		if quantity == 0 {
+			break
+		}
+
+		if r.quantity <= quantity {
+			_, err = tx.ExecContext(ctx, `DELETE FROM stock_reservations WHERE id = $1`, r.id)
+			quantity -= r.quantity
+		} else {
+			_, err = tx.ExecContext(ctx, `UPDATE stock_reservations SET quantity = quantity - $1 WHERE id = $2`, quantity, r.id)
+			quantity = 0
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,700 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/validator"
+)
+
+const (
+	ReturnStatusRequested = "requested"
+	ReturnStatusApproved  = "approved"
+	ReturnStatusReceived  = "received"
+	ReturnStatusRefunded  = "refunded"
+	ReturnStatusRejected  = "rejected"
+)
+
+// returnTransitions lists, for every return status, the statuses a return may move to next.
+// Refunded and rejected are final.
+var returnTransitions = map[string][]string{
+	ReturnStatusRequested: {ReturnStatusApproved, ReturnStatusRejected},
+	ReturnStatusApproved:  {ReturnStatusReceived},
+	ReturnStatusReceived:  {ReturnStatusRefunded},
+	ReturnStatusRefunded:  {},
+	ReturnStatusRejected:  {},
+}
+
+// attachmentExtensions maps the accepted content types of return attachments to the extension of
+// the stored file.
+var attachmentExtensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"application/pdf": ".pdf",
+}
+
+// Purchase records what a user has paid for a product, either through a captured buy-now payment
+// (PaymentID) or as an item of a paid order (OrderItemID). Amount is in minor units of Currency.
+type Purchase struct {
+	ID          int64     `json:"id"`
+	UserID      int64     `json:"user_id"`
+	ProductID   int       `json:"product_id,omitempty"`
+	SellerID    int       `json:"seller_id,omitempty"`
+	PaymentID   int64     `json:"payment_id,omitempty"`
+	OrderItemID int64     `json:"order_item_id,omitempty"`
+	Quantity    int       `json:"quantity"`
+	Amount      int64     `json:"amount"`
+	Currency    string    `json:"currency"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Return is the request of a buyer to send a purchase back.
+type Return struct {
+	ID          int64               `json:"id"`
+	PurchaseID  int64               `json:"purchase_id"`
+	UserID      int64               `json:"user_id"`
+	Reason      string              `json:"reason"`
+	Status      string              `json:"status"`
+	Purchase    *Purchase           `json:"purchase,omitempty"`
+	Attachments []*ReturnAttachment `json:"attachments"`
+	History     []*ReturnEvent      `json:"history,omitempty"`
+	CreatedAt   time.Time           `json:"created_at"`
+	UpdatedAt   time.Time           `json:"updated_at"`
+	Version     int                 `json:"-"`
+}
+
+// ReturnAttachment is a file, e.g. a photo of a damaged item, attached to a return. The file lives
+// in the storage under StorageKey; URL is filled in by the application.
+type ReturnAttachment struct {
+	ID          int64     `json:"id"`
+	ReturnID    int64     `json:"-"`
+	StorageKey  string    `json:"-"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	URL         string    `json:"url"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ReturnEvent is an entry of the audit trail of a return.
+type ReturnEvent struct {
+	FromStatus string    `json:"from_status,omitempty"`
+	ToStatus   string    `json:"to_status"`
+	ChangedBy  int64     `json:"changed_by,omitempty"`
+	Note       string    `json:"note,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+var (
+	// ErrDuplicateReturn is returned when a return is requested for a purchase which already has
+	// a return that hasn't been rejected.
+	ErrDuplicateReturn = errors.New("duplicate return")
+)
+
+// MaxReturnAttachments is the number of files which can be attached to a return.
+const MaxReturnAttachments = 5
+
+type PurchaseModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+type ReturnModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// CanTransition reports whether the return may be moved from its current status to status.
+func (r *Return) CanTransition(status string) bool {
+	return validator.In(status, returnTransitions[r.Status]...)
+}
+
+// Open reports whether the return hasn't reached a final status yet.
+func (r *Return) Open() bool {
+	return len(returnTransitions[r.Status]) > 0
+}
+
+// ValidReturnStatus reports whether status is one of the known return statuses.
+func ValidReturnStatus(status string) bool {
+	_, ok := returnTransitions[status]
+	return ok
+}
+
+// AttachmentExtension returns the extension of stored attachments of the given content type, and
+// false if attachments of that type can't be uploaded.
+func AttachmentExtension(contentType string) (string, bool) {
+	ext, ok := attachmentExtensions[contentType]
+	return ext, ok
+}
+
+func ValidateReturn(v *validator.Validator, ret *Return) {
+	v.Check(ret.Reason != "", "reason", "must be provided")
+	v.Check(len(ret.Reason) <= 2000, "reason", "must not be more than 2000 bytes long")
+}
+
+func (m PurchaseModel) Get(id int64) (*Purchase, error) {
+	query := `
+		SELECT id, user_id, COALESCE(product_id, 0), COALESCE(seller_id, 0), COALESCE(payment_id, 0),
+			COALESCE(order_item_id, 0), quantity, amount, currency, created_at
+		FROM purchases
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	purchase, err := scanPurchase(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return purchase, nil
+}
+
+// GetAllForUser returns a page of the purchases of the given user.
+func (m PurchaseModel) GetAllForUser(userID int64, filters Filters) ([]*Purchase, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, user_id, COALESCE(product_id, 0), COALESCE(seller_id, 0), COALESCE(payment_id, 0),
+			COALESCE(order_item_id, 0), quantity, amount, currency, created_at
+		FROM purchases
+		WHERE user_id = $1
+		ORDER BY %s %s, id
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	totalRecords := 0
+	purchases := []*Purchase{}
+
+	for rows.Next() {
+		var purchase Purchase
+
+		err := rows.Scan(
+			&totalRecords,
+			&purchase.ID,
+			&purchase.UserID,
+			&purchase.ProductID,
+			&purchase.SellerID,
+			&purchase.PaymentID,
+			&purchase.OrderItemID,
+			&purchase.Quantity,
+			&purchase.Amount,
+			&purchase.Currency,
+			&purchase.CreatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		purchases = append(purchases, &purchase)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return purchases, metadata, nil
+}
+
+// insertPaymentPurchase records the purchase paid by a captured payment and posts the sale to the
+// ledger.
+func insertPaymentPurchase(ctx context.Context, tx *sql.Tx, payment *Payment, commissionBPS int) error {
+	query := `
+		INSERT INTO purchases (user_id, product_id, seller_id, payment_id, quantity, amount, currency)
+		VALUES ($1, NULLIF($2, 0), NULLIF($3, 0), $4, $5, $6, $7)
+		ON CONFLICT (payment_id) DO NOTHING
+		RETURNING id`
+
+	args := []interface{}{payment.UserID, payment.ProductID, payment.SellerID, payment.ID, payment.Quantity,
+		payment.Amount, payment.Currency}
+
+	var id int64
+
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			// The purchase has already been recorded.
+			return nil
+		default:
+			return err
+		}
+	}
+
+	return postPurchaseSale(ctx, tx, id, commissionBPS)
+}
+
+// insertOrderPurchases records a purchase for every item of a paid order and posts the sales to
+// the ledger.
+func insertOrderPurchases(ctx context.Context, tx *sql.Tx, errorLog *log.Logger, orderID int64, commissionBPS int) error {
+	query := `
+		INSERT INTO purchases (user_id, product_id, seller_id, order_item_id, quantity, amount, currency)
+		SELECT orders.user_id, order_items.product_id, order_items.seller_id, order_items.id, order_items.quantity,
+			(ROUND(order_items.unit_price * 100) * order_items.quantity - ROUND(order_items.discount * 100))::bigint, $2
+		FROM order_items
+		INNER JOIN orders ON orders.id = order_items.order_id
+		WHERE order_items.order_id = $1
+		ON CONFLICT (order_item_id) DO NOTHING
+		RETURNING id`
+
+	ids, err := queryIDs(ctx, tx, errorLog, query, orderID, DefaultCurrency)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		err := postPurchaseSale(ctx, tx, id, commissionBPS)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reverseOrderPurchases reverses the sales of the purchases of a refunded or cancelled order.
+func reverseOrderPurchases(ctx context.Context, tx *sql.Tx, errorLog *log.Logger, orderID int64) error {
+	query := `
+		SELECT purchases.id
+		FROM purchases
+		INNER JOIN order_items ON order_items.id = purchases.order_item_id
+		WHERE order_items.order_id = $1`
+
+	ids, err := queryIDs(ctx, tx, errorLog, query, orderID)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		err := reversePurchaseSale(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// queryIDs runs a query returning a single id column inside a transaction. The ids are read
+// completely, so the transaction can be used again afterwards.
+func queryIDs(ctx context.Context, tx *sql.Tx, errorLog *log.Logger, query string, args ...interface{}) ([]int64, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			errorLog.Println(err)
+		}
+	}()
+
+	var ids []int64
+
+	for rows.Next() {
+		var id int64
+
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+func scanPurchase(row rowScanner) (*Purchase, error) {
+	var purchase Purchase
+
+	err := row.Scan(
+		&purchase.ID,
+		&purchase.UserID,
+		&purchase.ProductID,
+		&purchase.SellerID,
+		&purchase.PaymentID,
+		&purchase.OrderItemID,
+		&purchase.Quantity,
+		&purchase.Amount,
+		&purchase.Currency,
+		&purchase.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &purchase, nil
+}
+
+// Insert adds a requested return and the first entry of its audit trail. It returns
+// ErrDuplicateReturn if the purchase already has a return which hasn't been rejected.
+func (m ReturnModel) Insert(ret *Return) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO returns (purchase_id, user_id, reason, status)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, updated_at, version`
+
+	ret.Status = ReturnStatusRequested
+
+	err = tx.QueryRowContext(ctx, query, ret.PurchaseID, ret.UserID, ret.Reason, ret.Status).Scan(
+		&ret.ID, &ret.CreatedAt, &ret.UpdatedAt, &ret.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "returns_purchase_id_open_idx"`:
+			return ErrDuplicateReturn
+		default:
+			return err
+		}
+	}
+
+	err = insertReturnEvent(ctx, tx, ret.ID, "", ret.Status, ret.UserID, "")
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Get returns the return with the given id together with its purchase, attachments and audit
+// trail.
+func (m ReturnModel) Get(id int64) (*Return, error) {
+	query := `
+		SELECT returns.id, returns.purchase_id, returns.user_id, returns.reason, returns.status, returns.created_at,
+			returns.updated_at, returns.version, purchases.id, purchases.user_id, COALESCE(purchases.product_id, 0),
+			COALESCE(purchases.seller_id, 0), COALESCE(purchases.payment_id, 0), COALESCE(purchases.order_item_id, 0),
+			purchases.quantity, purchases.amount, purchases.currency, purchases.created_at
+		FROM returns
+		INNER JOIN purchases ON purchases.id = returns.purchase_id
+		WHERE returns.id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	ret := Return{Purchase: &Purchase{}}
+	purchase := ret.Purchase
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&ret.ID,
+		&ret.PurchaseID,
+		&ret.UserID,
+		&ret.Reason,
+		&ret.Status,
+		&ret.CreatedAt,
+		&ret.UpdatedAt,
+		&ret.Version,
+		&purchase.ID,
+		&purchase.UserID,
+		&purchase.ProductID,
+		&purchase.SellerID,
+		&purchase.PaymentID,
+		&purchase.OrderItemID,
+		&purchase.Quantity,
+		&purchase.Amount,
+		&purchase.Currency,
+		&purchase.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	ret.Attachments, err = m.attachments(ctx, ret.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	ret.History, err = m.history(ctx, ret.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ret, nil
+}
+
+// GetAllForUser returns a page of the returns the given user has requested as a buyer or received
+// as the seller of the purchased product, optionally restricted to a single status.
+func (m ReturnModel) GetAllForUser(userID int64, status string, filters Filters) ([]*Return, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), returns.id, returns.purchase_id, returns.user_id, returns.reason, returns.status,
+			returns.created_at, returns.updated_at, returns.version
+		FROM returns
+		INNER JOIN purchases ON purchases.id = returns.purchase_id
+		LEFT JOIN sellers ON sellers.seller_id = purchases.seller_id
+		WHERE (returns.user_id = $1 OR sellers.user_id = $1)
+			AND (returns.status = $2 OR $2 = '')
+		ORDER BY returns.%s %s, returns.id
+		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	totalRecords := 0
+	returns := []*Return{}
+
+	for rows.Next() {
+		var ret Return
+
+		err := rows.Scan(
+			&totalRecords,
+			&ret.ID,
+			&ret.PurchaseID,
+			&ret.UserID,
+			&ret.Reason,
+			&ret.Status,
+			&ret.CreatedAt,
+			&ret.UpdatedAt,
+			&ret.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		ret.Attachments = []*ReturnAttachment{}
+		returns = append(returns, &ret)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return returns, metadata, nil
+}
+
+// UpdateStatus moves the return to a new status and adds an entry with the note to its audit
+// trail. When the returned item is received it is put back into the stock of the product, and
+// when it is refunded the sale is reversed in the ledger. It returns ErrInvalidTransition if the
+// new status isn't reachable from the current one and ErrEditConflict if the return was changed
+// concurrently.
+func (m ReturnModel) UpdateStatus(ret *Return, status string, changedBy int64, note string) error {
+	if !ret.CanTransition(status) {
+		return ErrInvalidTransition
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE returns
+		SET status = $1, updated_at = NOW(), version = version + 1
+		WHERE id = $2 AND version = $3
+		RETURNING updated_at, version`
+
+	err = tx.QueryRowContext(ctx, query, status, ret.ID, ret.Version).Scan(&ret.UpdatedAt, &ret.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if status == ReturnStatusReceived {
+		err = restockReturnedPurchase(ctx, tx, ret.PurchaseID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if status == ReturnStatusRefunded {
+		err = reversePurchaseSale(ctx, tx, ret.PurchaseID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = insertReturnEvent(ctx, tx, ret.ID, ret.Status, status, changedBy, note)
+	if err != nil {
+		return err
+	}
+
+	ret.Status = status
+
+	return tx.Commit()
+}
+
+// restockReturnedPurchase puts the quantity of a returned purchase back into the stock of the
+// product. A purchase whose sale has already been reversed, e.g. because its order was cancelled
+// and restocked, is skipped so that the items aren't put back twice.
+func restockReturnedPurchase(ctx context.Context, tx *sql.Tx, purchaseID int64) error {
+	// Lock the product first, so that the check below sees every reversal committed before.
+	query := `
+		SELECT products.product_id
+		FROM products
+		INNER JOIN purchases ON purchases.product_id = products.product_id
+		WHERE purchases.id = $1
+		FOR UPDATE OF products`
+
+	var productID int
+
+	err := tx.QueryRowContext(ctx, query, purchaseID).Scan(&productID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			// The product has been deleted since.
+			return nil
+		default:
+			return err
+		}
+	}
+
+	reversed, err := purchaseSaleReversed(ctx, tx, purchaseID)
+	if err != nil {
+		return err
+	}
+
+	if reversed {
+		return nil
+	}
+
+	query = `
+		UPDATE products
+		SET stock = products.stock + purchases.quantity
+		FROM purchases
+		WHERE purchases.id = $1 AND products.product_id = purchases.product_id`
+
+	_, err = tx.ExecContext(ctx, query, purchaseID)
+	return err
+}
+
+// InsertAttachment adds a file to a return. The file has to be stored by the caller.
+func (m ReturnModel) InsertAttachment(attachment *ReturnAttachment) error {
+	query := `
+		INSERT INTO return_attachments (return_id, storage_key, content_type, size_bytes)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`
+
+	args := []interface{}{attachment.ReturnID, attachment.StorageKey, attachment.ContentType, attachment.Size}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&attachment.ID, &attachment.CreatedAt)
+}
+
+func (m ReturnModel) attachments(ctx context.Context, returnID int64) ([]*ReturnAttachment, error) {
+	query := `
+		SELECT id, return_id, storage_key, content_type, size_bytes, created_at
+		FROM return_attachments
+		WHERE return_id = $1
+		ORDER BY id`
+
+	rows, err := m.DB.QueryContext(ctx, query, returnID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	attachments := []*ReturnAttachment{}
+
+	for rows.Next() {
+		var attachment ReturnAttachment
+
+		err := rows.Scan(&attachment.ID, &attachment.ReturnID, &attachment.StorageKey, &attachment.ContentType,
+			&attachment.Size, &attachment.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		attachments = append(attachments, &attachment)
+	}
+
+	return attachments, rows.Err()
+}
+
+func (m ReturnModel) history(ctx context.Context, returnID int64) ([]*ReturnEvent, error) {
+	query := `
+		SELECT COALESCE(from_status, ''), to_status, COALESCE(changed_by, 0), note, created_at
+		FROM return_events
+		WHERE return_id = $1
+		ORDER BY created_at, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, returnID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	var events []*ReturnEvent
+
+	for rows.Next() {
+		var event ReturnEvent
+
+		err := rows.Scan(&event.FromStatus, &event.ToStatus, &event.ChangedBy, &event.Note, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, &event)
+	}
+
+	return events, rows.Err()
+}
+
+func insertReturnEvent(ctx context.Context, tx *sql.Tx, returnID int64, from, to string, changedBy int64, note string) error {
+	query := `
+		INSERT INTO return_events (return_id, from_status, to_status, changed_by, note)
+		VALUES ($1, NULLIF($2, ''), $3, NULLIF($4::bigint, 0), $5)`
+
+	_, err := tx.ExecContext(ctx, query, returnID, from, to, changedBy, note)
+	return err
+}
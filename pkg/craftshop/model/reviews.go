@@ -0,0 +1,263 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/validator"
+)
+
+type Review struct {
+	ID        int64     `json:"id"`
+	ProductID int       `json:"product_id"`
+	UserID    int64     `json:"user_id"`
+	UserName  string    `json:"user_name"`
+	Rating    int       `json:"rating"`
+	Comment   string    `json:"comment"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Version   int       `json:"-"`
+}
+
+var (
+	// ErrDuplicateReview is returned when a user tries to review a product for a second time.
+	ErrDuplicateReview = errors.New("duplicate review")
+)
+
+type ReviewModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Rating >= 1, "rating", "must be at least 1")
+	v.Check(review.Rating <= 5, "rating", "must not be more than 5")
+	v.Check(len(review.Comment) <= 2000, "comment", "must not be more than 2000 bytes long")
+}
+
+// Insert adds a review and refreshes the aggregated rating of the product in the same
+// transaction. It returns ErrDuplicateReview if the user has already reviewed the product.
+func (m ReviewModel) Insert(review *Review) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO reviews (product_id, user_id, rating, comment)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, updated_at, version`
+
+	args := []interface{}{review.ProductID, review.UserID, review.Rating, review.Comment}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.CreatedAt, &review.UpdatedAt, &review.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "reviews_product_id_user_id_key"`:
+			return ErrDuplicateReview
+		default:
+			return err
+		}
+	}
+
+	err = refreshProductRating(ctx, tx, review.ProductID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Get returns a review of the given product.
+func (m ReviewModel) Get(productID int, id int64) (*Review, error) {
+	query := `
+		SELECT reviews.id, reviews.product_id, reviews.user_id, users.name, reviews.rating, reviews.comment,
+			reviews.created_at, reviews.updated_at, reviews.version
+		FROM reviews
+		INNER JOIN users ON users.id = reviews.user_id
+		WHERE reviews.id = $1 AND reviews.product_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var review Review
+
+	err := m.DB.QueryRowContext(ctx, query, id, productID).Scan(
+		&review.ID,
+		&review.ProductID,
+		&review.UserID,
+		&review.UserName,
+		&review.Rating,
+		&review.Comment,
+		&review.CreatedAt,
+		&review.UpdatedAt,
+		&review.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &review, nil
+}
+
+// GetAllForProduct returns a page of the reviews of a product.
+func (m ReviewModel) GetAllForProduct(productID int, filters Filters) ([]*Review, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), reviews.id, reviews.product_id, reviews.user_id, users.name, reviews.rating,
+			reviews.comment, reviews.created_at, reviews.updated_at, reviews.version
+		FROM reviews
+		INNER JOIN users ON users.id = reviews.user_id
+		WHERE reviews.product_id = $1
+		ORDER BY reviews.%s %s, reviews.id
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, productID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	totalRecords := 0
+	reviews := []*Review{}
+
+	for rows.Next() {
+		var review Review
+
+		err := rows.Scan(
+			&totalRecords,
+			&review.ID,
+			&review.ProductID,
+			&review.UserID,
+			&review.UserName,
+			&review.Rating,
+			&review.Comment,
+			&review.CreatedAt,
+			&review.UpdatedAt,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		reviews = append(reviews, &review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return reviews, metadata, nil
+}
+
+// Update changes the rating and comment of a review and refreshes the aggregated rating of the
+// product. It returns ErrEditConflict if the review was changed concurrently.
+func (m ReviewModel) Update(review *Review) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE reviews
+		SET rating = $1, comment = $2, updated_at = NOW(), version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING updated_at, version`
+
+	args := []interface{}{review.Rating, review.Comment, review.ID, review.Version}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&review.UpdatedAt, &review.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = refreshProductRating(ctx, tx, review.ProductID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes a review and refreshes the aggregated rating of the product.
+func (m ReviewModel) Delete(review *Review) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM reviews WHERE id = $1`, review.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = refreshProductRating(ctx, tx, review.ProductID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// refreshProductRating recalculates the average rating and review count stored on a product.
+func refreshProductRating(ctx context.Context, tx *sql.Tx, productID int) error {
+	// Lock the product first. Under READ COMMITTED the aggregates below then run in a statement
+	// which sees the reviews committed by every transaction which held the lock before;
+	// aggregating in the locking statement would miss them.
+	_, err := tx.ExecContext(ctx, `SELECT product_id FROM products WHERE product_id = $1 FOR UPDATE`, productID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE products
+		SET rating_average = COALESCE((SELECT ROUND(AVG(rating), 2) FROM reviews WHERE product_id = $1), 0),
+			rating_count = (SELECT COUNT(*) FROM reviews WHERE product_id = $1)
+		WHERE product_id = $1`
+
+	_, err = tx.ExecContext(ctx, query, productID)
+	return err
+}
@@ -3,6 +3,7 @@ package model
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 
 	// "fmt"
@@ -12,35 +13,50 @@ import (
 	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/validator"
 )
 
+// Seller is the shop profile of a user. UserID is zero for sellers which were created before
+// profiles were attached to user accounts. Like for users, the password is kept as a bcrypt hash
+// and never appears in JSON output.
 type Seller struct {
-	SellerID   int    `json:"seller_id"`
-	SellerName string `json:"seller_name"`
-	Email      string `json:"email"`
-	Password   string `json:"password"`
-	Location   string `json:"location"`
-	DateJoined string `json:"date_joined"`
+	SellerID   int      `json:"seller_id"`
+	UserID     int64    `json:"user_id,omitempty"`
+	SellerName string   `json:"seller_name"`
+	Email      string   `json:"email"`
+	Password   password `json:"-"`
+	Location   string   `json:"location"`
+	DateJoined string   `json:"date_joined"`
 }
+
+var (
+	// ErrDuplicateSeller is returned when a user who already has a seller profile creates another.
+	ErrDuplicateSeller = errors.New("duplicate seller")
+)
 type SellerModel struct {
 	DB       *sql.DB
 	InfoLog  *log.Logger
 	ErrorLog *log.Logger
 }
 
+// Insert creates the seller profile of seller.UserID. It returns ErrDuplicateSeller if the user
+// already has a profile and ErrDuplicateEmail if another seller uses the email address.
 func (s SellerModel) Insert(seller *Seller) error {
 	query := `
-	INSERT INTO sellers (seller_name, email, password, location)
-	VALUES($1, $2, $3, $4) 
+	INSERT INTO sellers (user_id, seller_name, email, password_hash, location)
+	VALUES($1, $2, $3, $4, $5) 
 	RETURNING seller_id, date_joined
 	`
-	args := []interface{}{seller.SellerName, seller.Email, seller.Password, seller.Location}
+	args := []interface{}{seller.UserID, seller.SellerName, seller.Email, seller.Password.hash, seller.Location}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return s.DB.QueryRowContext(ctx, query, args...).Scan(&seller.SellerID, &seller.DateJoined)
+	err := s.DB.QueryRowContext(ctx, query, args...).Scan(&seller.SellerID, &seller.DateJoined)
+	if err != nil {
+		return sellerWriteError(err)
+	}
+	return nil
 }
 func (s SellerModel) Get(id int) (*Seller, error) {
 	query := `
-        SELECT seller_id, seller_name, email, password, location
+        SELECT seller_id, COALESCE(user_id, 0), seller_name, email, password_hash, location, date_joined
         FROM sellers
         WHERE seller_id = $1
     `
@@ -49,9 +65,62 @@ func (s SellerModel) Get(id int) (*Seller, error) {
 	defer cancel()
 
 	row := s.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(&seller.SellerID, &seller.SellerName, &seller.Email, &seller.Password, &seller.Location)
+	err := row.Scan(&seller.SellerID, &seller.UserID, &seller.SellerName, &seller.Email, &seller.Password.hash, &seller.Location, &seller.DateJoined)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &seller, nil
+}
+
+// GetForUser returns the seller profile of a user.
+func (s SellerModel) GetForUser(userID int64) (*Seller, error) {
+	query := `
+        SELECT seller_id, user_id, seller_name, email, password_hash, location, date_joined
+        FROM sellers
+        WHERE user_id = $1
+    `
+	var seller Seller
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	row := s.DB.QueryRowContext(ctx, query, userID)
+	err := row.Scan(&seller.SellerID, &seller.UserID, &seller.SellerName, &seller.Email, &seller.Password.hash, &seller.Location, &seller.DateJoined)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &seller, nil
+}
+
+// GetByEmail returns the seller with the given email address. It is used to sign sellers in.
+func (s SellerModel) GetByEmail(email string) (*Seller, error) {
+	query := `
+        SELECT seller_id, COALESCE(user_id, 0), seller_name, email, password_hash, location, date_joined
+        FROM sellers
+        WHERE LOWER(email) = LOWER($1)
+    `
+	var seller Seller
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	row := s.DB.QueryRowContext(ctx, query, email)
+	err := row.Scan(&seller.SellerID, &seller.UserID, &seller.SellerName, &seller.Email, &seller.Password.hash, &seller.Location, &seller.DateJoined)
 	if err != nil {
-		return nil, err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
 	}
 	return &seller, nil
 }
@@ -59,15 +128,36 @@ func (s SellerModel) Get(id int) (*Seller, error) {
 func (s SellerModel) Update(seller *Seller) error {
 	query := `
         UPDATE Sellers
-        SET seller_name = $1, email = $2, password = $3, location = $4
+        SET seller_name = $1, email = $2, password_hash = $3, location = $4
         WHERE seller_id = $5
         RETURNING date_joined
     `
-	args := []interface{}{seller.SellerName, seller.Email, seller.Password, seller.Location, seller.SellerID}
+	args := []interface{}{seller.SellerName, seller.Email, seller.Password.hash, seller.Location, seller.SellerID}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return s.DB.QueryRowContext(ctx, query, args...).Scan(&seller.DateJoined)
+	err := s.DB.QueryRowContext(ctx, query, args...).Scan(&seller.DateJoined)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return sellerWriteError(err)
+		}
+	}
+	return nil
+}
+
+// sellerWriteError translates unique constraint violations on the sellers table.
+func sellerWriteError(err error) error {
+	switch {
+	case err.Error() == `pq: duplicate key value violates unique constraint "sellers_user_id_key"`:
+		return ErrDuplicateSeller
+	case err.Error() == `pq: duplicate key value violates unique constraint "sellers_email_key"`:
+		return ErrDuplicateEmail
+	default:
+		return err
+	}
 }
 
 func (s SellerModel) Delete(id int) error {
@@ -84,7 +174,7 @@ func (s SellerModel) Delete(id int) error {
 
 func (s SellerModel) GetAll(location string, filters Filters) ([]*Seller, Metadata, error) {
     query := fmt.Sprintf(`
-        SELECT count(*) OVER(), seller_id, seller_name, email, password, location, date_joined
+        SELECT count(*) OVER(), seller_id, COALESCE(user_id, 0), seller_name, email, location, date_joined
         FROM sellers
         WHERE location = $1
         ORDER BY %s %s, seller_id
@@ -109,9 +199,9 @@ func (s SellerModel) GetAll(location string, filters Filters) ([]*Seller, Metada
         err := rows.Scan(
             &totalRecords,
             &seller.SellerID,
+            &seller.UserID,
             &seller.SellerName,
             &seller.Email,
-            &seller.Password,
             &seller.Location,
             &seller.DateJoined,
         )
@@ -143,7 +233,8 @@ func ValidateSeller(v *validator.Validator, seller *Seller) {
 	v.Check(seller.Location != "", "location", "must be provided")
 	// Check if the location field is not more than 100 characters.
 	v.Check(len(seller.Location) <= 100, "location", "must not be more than 100 characters long")
-	v.Check(seller.Password != "", "password", "must be provided")
-	// Check if the password field is at least 6 characters long.
-	v.Check(len(seller.Password) >= 6, "password", "must be at least 6 characters long")
+	// The password is optional, but a new one has to satisfy the same rules as user passwords.
+	if seller.Password.plaintext != nil {
+		ValidatePasswordPlaintext(v, *seller.Password.plaintext)
+	}
 }
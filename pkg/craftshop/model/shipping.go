@@ -0,0 +1,473 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/validator"
+	"github.com/lib/pq"
+)
+
+const (
+	ShippingRateFlat   = "flat"
+	ShippingRateWeight = "weight"
+)
+
+// ShippingEverywhere is the country of a zone which covers every country not covered by another
+// zone of the profile.
+const ShippingEverywhere = "*"
+
+var (
+	// CountryRX matches ISO 3166-1 alpha-2 country codes.
+	CountryRX = regexp.MustCompile("^[A-Z]{2}$")
+
+	// RegionRX matches ISO 3166-2 subdivision codes, e.g. "US-CA".
+	RegionRX = regexp.MustCompile("^[A-Z]{2}-[A-Z0-9]{1,3}$")
+)
+
+// ShippingProfile describes how a seller ships their products: how many days it takes to prepare
+// an order and what shipping costs in every zone.
+type ShippingProfile struct {
+	ID                int64           `json:"id"`
+	SellerID          int             `json:"seller_id"`
+	Name              string          `json:"name"`
+	ProcessingDaysMin int             `json:"processing_days_min"`
+	ProcessingDaysMax int             `json:"processing_days_max"`
+	Zones             []*ShippingZone `json:"zones"`
+	CreatedAt         time.Time       `json:"created_at"`
+	Version           int             `json:"-"`
+}
+
+// ShippingZone is a set of destinations which share a shipping rate. Countries lists ISO 3166-1
+// alpha-2 codes, or ShippingEverywhere; Regions optionally narrows them down to ISO 3166-2
+// subdivisions. Rates are in cents: a flat rate charges BaseRate plus ExtraItemRate for every
+// further item, a weight rate charges BaseRate plus PerKgRate for every started kilogram. An
+// order of at least FreeOver cents ships for free, unless FreeOver is 0.
+type ShippingZone struct {
+	ID             int64    `json:"id"`
+	Name           string   `json:"name"`
+	Countries      []string `json:"countries"`
+	Regions        []string `json:"regions"`
+	RateType       string   `json:"rate_type"`
+	BaseRate       int64    `json:"base_rate"`
+	ExtraItemRate  int64    `json:"extra_item_rate"`
+	PerKgRate      int64    `json:"per_kg_rate"`
+	FreeOver       int64    `json:"free_over"`
+	TransitDaysMin int      `json:"transit_days_min"`
+	TransitDaysMax int      `json:"transit_days_max"`
+}
+
+// ShippingQuote is the price of shipping a quantity of a product to a destination. Amounts are in
+// minor units of Currency. The delivery estimate adds the processing time of the profile to the
+// transit time of the zone. Coupon is the code of the free shipping coupon applied to the quote.
+type ShippingQuote struct {
+	ProductID       int    `json:"product_id"`
+	Country         string `json:"country"`
+	Region          string `json:"region,omitempty"`
+	Quantity        int    `json:"quantity"`
+	Zone            string `json:"zone"`
+	Subtotal        int64  `json:"subtotal"`
+	Cost            int64  `json:"cost"`
+	FreeShipping    bool   `json:"free_shipping"`
+	Coupon          string `json:"coupon,omitempty"`
+	Currency        string `json:"currency"`
+	DeliveryDaysMin int    `json:"delivery_days_min"`
+	DeliveryDaysMax int    `json:"delivery_days_max"`
+	Note            string `json:"note,omitempty"`
+}
+
+var (
+	// ErrNoShippingZone is returned when a product isn't shipped to a destination.
+	ErrNoShippingZone = errors.New("no shipping zone")
+)
+
+type ShippingProfileModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateShippingProfile(v *validator.Validator, profile *ShippingProfile) {
+	v.Check(profile.Name != "", "name", "must be provided")
+	v.Check(len(profile.Name) <= 100, "name", "must not be more than 100 characters long")
+	v.Check(profile.ProcessingDaysMin >= 0, "processing_days_min", "must not be negative")
+	v.Check(profile.ProcessingDaysMax >= profile.ProcessingDaysMin, "processing_days_max", "must not be less than processing_days_min")
+	v.Check(profile.ProcessingDaysMax <= 90, "processing_days_max", "must not be more than 90")
+
+	v.Check(len(profile.Zones) > 0, "zones", "must contain at least one zone")
+	v.Check(len(profile.Zones) <= 50, "zones", "must not contain more than 50 zones")
+
+	for i, zone := range profile.Zones {
+		key := fmt.Sprintf("zones[%d]", i)
+
+		if zone == nil {
+			v.AddError(key, "must not be null")
+			continue
+		}
+
+		v.Check(zone.Name != "", key+".name", "must be provided")
+		v.Check(len(zone.Name) <= 100, key+".name", "must not be more than 100 characters long")
+
+		v.Check(len(zone.Countries) > 0, key+".countries", "must contain at least one country")
+		v.Check(validator.Unique(zone.Countries), key+".countries", "must not contain duplicate values")
+		for _, country := range zone.Countries {
+			v.Check(country == ShippingEverywhere || validator.Matches(country, CountryRX), key+".countries", "must contain ISO 3166-1 alpha-2 country codes or *")
+		}
+
+		v.Check(validator.Unique(zone.Regions), key+".regions", "must not contain duplicate values")
+		for _, region := range zone.Regions {
+			v.Check(validator.Matches(region, RegionRX), key+".regions", "must contain ISO 3166-2 region codes")
+			v.Check(validator.In(strings.SplitN(region, "-", 2)[0], zone.Countries...), key+".regions", "must only contain regions of the countries of the zone")
+		}
+
+		v.Check(validator.In(zone.RateType, ShippingRateFlat, ShippingRateWeight), key+".rate_type", "must be flat or weight")
+		v.Check(zone.BaseRate >= 0, key+".base_rate", "must not be negative")
+		v.Check(zone.ExtraItemRate >= 0, key+".extra_item_rate", "must not be negative")
+		v.Check(zone.PerKgRate >= 0, key+".per_kg_rate", "must not be negative")
+		v.Check(zone.FreeOver >= 0, key+".free_over", "must not be negative")
+		v.Check(zone.TransitDaysMin >= 0, key+".transit_days_min", "must not be negative")
+		v.Check(zone.TransitDaysMax >= zone.TransitDaysMin, key+".transit_days_max", "must not be less than transit_days_min")
+		v.Check(zone.TransitDaysMax <= 180, key+".transit_days_max", "must not be more than 180")
+	}
+}
+
+// Zone returns the zone which covers the destination. region is an ISO 3166-2 code and may be
+// empty. A zone listing the region beats one listing only the country, which beats the
+// ShippingEverywhere zone; between equally specific zones the first one wins.
+func (p *ShippingProfile) Zone(country, region string) (*ShippingZone, bool) {
+	var best *ShippingZone
+	bestScore := 0
+
+	for _, zone := range p.Zones {
+		score := 0
+
+		switch {
+		case validator.In(country, zone.Countries...) && len(zone.Regions) == 0:
+			score = 2
+		case validator.In(country, zone.Countries...) && region != "" && validator.In(region, zone.Regions...):
+			score = 3
+		case validator.In(ShippingEverywhere, zone.Countries...):
+			score = 1
+		}
+
+		if score > bestScore {
+			best, bestScore = zone, score
+		}
+	}
+
+	return best, best != nil
+}
+
+// Cost returns the cost of shipping quantity items of the given weight and subtotal with the
+// zone, and whether the shipping is free because the subtotal reaches FreeOver.
+func (z *ShippingZone) Cost(quantity, weightGrams int, subtotal int64) (int64, bool) {
+	if z.FreeOver > 0 && subtotal >= z.FreeOver {
+		return 0, true
+	}
+
+	switch z.RateType {
+	case ShippingRateWeight:
+		kilograms := int64(math.Ceil(float64(weightGrams*quantity) / 1000))
+		return z.BaseRate + z.PerKgRate*kilograms, false
+	default:
+		return z.BaseRate + z.ExtraItemRate*int64(quantity-1), false
+	}
+}
+
+// Quote prices shipping quantity items of the product to the destination with the profile. It
+// returns ErrNoShippingZone if none of the zones covers the destination.
+func (p *ShippingProfile) Quote(product *Product, quantity int, country, region string) (*ShippingQuote, error) {
+	country = strings.ToUpper(country)
+	region = RegionCode(country, region)
+
+	zone, ok := p.Zone(country, region)
+	if !ok {
+		return nil, ErrNoShippingZone
+	}
+
+	quote := &ShippingQuote{
+		ProductID:       product.ID,
+		Country:         country,
+		Region:          region,
+		Quantity:        quantity,
+		Zone:            zone.Name,
+		Subtotal:        product.Price.Mul(quantity).Amount,
+		Currency:        DefaultCurrency,
+		DeliveryDaysMin: p.ProcessingDaysMin + zone.TransitDaysMin,
+		DeliveryDaysMax: p.ProcessingDaysMax + zone.TransitDaysMax,
+		Note:            product.ShippingNote,
+	}
+
+	quote.Cost, quote.FreeShipping = zone.Cost(quantity, product.WeightGrams, quote.Subtotal)
+
+	return quote, nil
+}
+
+// RegionCode returns the ISO 3166-2 code of a region of the country. Regions may be given without
+// their country, e.g. "CA" for "US-CA".
+func RegionCode(country, region string) string {
+	region = strings.ToUpper(region)
+
+	if region != "" && !strings.HasPrefix(region, country+"-") {
+		region = country + "-" + region
+	}
+
+	return region
+}
+
+// Insert creates a shipping profile together with its zones.
+func (m ShippingProfileModel) Insert(profile *ShippingProfile) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO shipping_profiles (seller_id, name, processing_days_min, processing_days_max)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version`
+
+	args := []interface{}{profile.SellerID, profile.Name, profile.ProcessingDaysMin, profile.ProcessingDaysMax}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&profile.ID, &profile.CreatedAt, &profile.Version)
+	if err != nil {
+		return err
+	}
+
+	err = saveShippingZones(ctx, tx, profile)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Get returns the shipping profile with the given id together with its zones.
+func (m ShippingProfileModel) Get(id int64) (*ShippingProfile, error) {
+	query := `
+		SELECT id, seller_id, name, processing_days_min, processing_days_max, created_at, version
+		FROM shipping_profiles
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var profile ShippingProfile
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&profile.ID, &profile.SellerID, &profile.Name,
+		&profile.ProcessingDaysMin, &profile.ProcessingDaysMax, &profile.CreatedAt, &profile.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = m.attachZones(ctx, []*ShippingProfile{&profile})
+	if err != nil {
+		return nil, err
+	}
+
+	return &profile, nil
+}
+
+// GetAllForSeller returns the shipping profiles of a seller together with their zones.
+func (m ShippingProfileModel) GetAllForSeller(sellerID int) ([]*ShippingProfile, error) {
+	query := `
+		SELECT id, seller_id, name, processing_days_min, processing_days_max, created_at, version
+		FROM shipping_profiles
+		WHERE seller_id = $1
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, sellerID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	profiles := []*ShippingProfile{}
+
+	for rows.Next() {
+		var profile ShippingProfile
+
+		err := rows.Scan(&profile.ID, &profile.SellerID, &profile.Name, &profile.ProcessingDaysMin,
+			&profile.ProcessingDaysMax, &profile.CreatedAt, &profile.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		profiles = append(profiles, &profile)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = m.attachZones(ctx, profiles)
+	if err != nil {
+		return nil, err
+	}
+
+	return profiles, nil
+}
+
+// Update saves the profile and replaces its zones. It returns ErrEditConflict if the profile was
+// changed concurrently.
+func (m ShippingProfileModel) Update(profile *ShippingProfile) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE shipping_profiles
+		SET name = $1, processing_days_min = $2, processing_days_max = $3, version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING version`
+
+	args := []interface{}{profile.Name, profile.ProcessingDaysMin, profile.ProcessingDaysMax, profile.ID, profile.Version}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&profile.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = saveShippingZones(ctx, tx, profile)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes a shipping profile. Products which used it are left without a profile.
+func (m ShippingProfileModel) Delete(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM shipping_profiles WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// attachZones loads the zones of all the given profiles with a single query.
+func (m ShippingProfileModel) attachZones(ctx context.Context, profiles []*ShippingProfile) error {
+	if len(profiles) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(profiles))
+	byID := make(map[int64]*ShippingProfile, len(profiles))
+
+	for i, profile := range profiles {
+		ids[i] = profile.ID
+		byID[profile.ID] = profile
+		profile.Zones = []*ShippingZone{}
+	}
+
+	query := `
+		SELECT profile_id, id, name, countries, regions, rate_type, base_rate, extra_item_rate, per_kg_rate, free_over,
+			transit_days_min, transit_days_max
+		FROM shipping_zones
+		WHERE profile_id = ANY($1)
+		ORDER BY profile_id, position`
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	for rows.Next() {
+		var profileID int64
+		var zone ShippingZone
+
+		err := rows.Scan(&profileID, &zone.ID, &zone.Name, pq.Array(&zone.Countries), pq.Array(&zone.Regions),
+			&zone.RateType, &zone.BaseRate, &zone.ExtraItemRate, &zone.PerKgRate, &zone.FreeOver,
+			&zone.TransitDaysMin, &zone.TransitDaysMax)
+		if err != nil {
+			return err
+		}
+
+		profile := byID[profileID]
+		profile.Zones = append(profile.Zones, &zone)
+	}
+
+	return rows.Err()
+}
+
+// saveShippingZones replaces the zones of a profile with profile.Zones.
+func saveShippingZones(ctx context.Context, tx *sql.Tx, profile *ShippingProfile) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM shipping_zones WHERE profile_id = $1`, profile.ID)
+	if err != nil {
+		return err
+	}
+
+	for position, zone := range profile.Zones {
+		if zone.Regions == nil {
+			zone.Regions = []string{}
+		}
+
+		query := `
+			INSERT INTO shipping_zones (profile_id, name, countries, regions, rate_type, base_rate, extra_item_rate,
+				per_kg_rate, free_over, transit_days_min, transit_days_max, position)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+			RETURNING id`
+
+		args := []interface{}{profile.ID, zone.Name, pq.Array(zone.Countries), pq.Array(zone.Regions), zone.RateType,
+			zone.BaseRate, zone.ExtraItemRate, zone.PerKgRate, zone.FreeOver, zone.TransitDaysMin, zone.TransitDaysMax,
+			position}
+
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&zone.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
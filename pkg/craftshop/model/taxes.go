@@ -0,0 +1,340 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/validator"
+	"github.com/ainelnazaraly/CraftShop/pkg/tax"
+	"github.com/lib/pq"
+)
+
+// TaxRule is a stored tax rate, see tax.Rule. RateBPS is in basis points, so 20% is 2000.
+type TaxRule struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Country   string    `json:"country"`
+	Region    string    `json:"region,omitempty"`
+	Category  string    `json:"category,omitempty"`
+	RateBPS   int       `json:"rate_bps"`
+	Inclusive bool      `json:"inclusive"`
+	CreatedAt time.Time `json:"created_at"`
+	Version   int       `json:"-"`
+}
+
+// QuoteLine is a product priced for a destination. Amounts are in cents: Net is the amount without
+// tax and Gross the amount the buyer pays.
+type QuoteLine struct {
+	ProductID    int    `json:"product_id"`
+	Name         string `json:"product_name"`
+	Category     string `json:"category"`
+	Quantity     int    `json:"quantity"`
+	UnitPrice    int64  `json:"unit_price"`
+	Net          int64  `json:"net"`
+	Tax          int64  `json:"tax"`
+	Gross        int64  `json:"gross"`
+	TaxRateBPS   int    `json:"tax_rate_bps"`
+	TaxRule      string `json:"tax_rule,omitempty"`
+	TaxInclusive bool   `json:"tax_inclusive"`
+}
+
+// Quote is the price of a list of products for a destination with the tax of every line. Amounts
+// are in minor units of Currency.
+type Quote struct {
+	Country  string       `json:"country"`
+	Region   string       `json:"region,omitempty"`
+	Currency string       `json:"currency"`
+	Lines    []*QuoteLine `json:"lines"`
+	Net      int64        `json:"net"`
+	Tax      int64        `json:"tax"`
+	Gross    int64        `json:"gross"`
+}
+
+var (
+	// ErrDuplicateTaxRule is returned when a tax rule is saved for the destination and category of
+	// another rule.
+	ErrDuplicateTaxRule = errors.New("duplicate tax rule")
+)
+
+// taxCountryRX matches the countries of tax rules: ISO 3166-1 alpha-2 codes or tax.Everywhere.
+var taxCountryRX = regexp.MustCompile(`^([A-Z]{2}|\*)$`)
+
+type TaxRuleModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateTaxRule(v *validator.Validator, rule *TaxRule) {
+	v.Check(rule.Name != "", "name", "must be provided")
+	v.Check(len(rule.Name) <= 100, "name", "must not be more than 100 characters long")
+	v.Check(validator.Matches(rule.Country, taxCountryRX), "country", "must be an ISO 3166-1 alpha-2 country code or *")
+
+	if rule.Region != "" {
+		v.Check(validator.Matches(rule.Region, RegionRX), "region", "must be an ISO 3166-2 region code")
+		v.Check(rule.Country != tax.Everywhere, "region", "must not be provided for rules applying everywhere")
+	}
+
+	v.Check(len(rule.Category) <= 100, "category", "must not be more than 100 characters long")
+	v.Check(rule.RateBPS >= 0, "rate_bps", "must not be negative")
+	v.Check(rule.RateBPS <= 10000, "rate_bps", "must not be more than 10000")
+}
+
+func (m TaxRuleModel) Insert(rule *TaxRule) error {
+	query := `
+		INSERT INTO tax_rules (name, country, region, category, rate_bps, inclusive)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at, version`
+
+	args := []interface{}{rule.Name, rule.Country, rule.Region, rule.Category, rule.RateBPS, rule.Inclusive}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&rule.ID, &rule.CreatedAt, &rule.Version)
+	if err != nil {
+		return taxRuleWriteError(err)
+	}
+
+	return nil
+}
+
+func (m TaxRuleModel) Get(id int64) (*TaxRule, error) {
+	query := `
+		SELECT id, name, country, region, category, rate_bps, inclusive, created_at, version
+		FROM tax_rules
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var rule TaxRule
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&rule.ID, &rule.Name, &rule.Country, &rule.Region,
+		&rule.Category, &rule.RateBPS, &rule.Inclusive, &rule.CreatedAt, &rule.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &rule, nil
+}
+
+// GetAll returns a page of the tax rules, optionally restricted to a single country.
+func (m TaxRuleModel) GetAll(country string, filters Filters) ([]*TaxRule, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, name, country, region, category, rate_bps, inclusive, created_at, version
+		FROM tax_rules
+		WHERE (country = $1 OR $1 = '')
+		ORDER BY %s %s, id
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, country, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	totalRecords := 0
+	rules := []*TaxRule{}
+
+	for rows.Next() {
+		var rule TaxRule
+
+		err := rows.Scan(&totalRecords, &rule.ID, &rule.Name, &rule.Country, &rule.Region, &rule.Category,
+			&rule.RateBPS, &rule.Inclusive, &rule.CreatedAt, &rule.Version)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		rules = append(rules, &rule)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return rules, metadata, nil
+}
+
+func (m TaxRuleModel) Update(rule *TaxRule) error {
+	query := `
+		UPDATE tax_rules
+		SET name = $1, country = $2, region = $3, category = $4, rate_bps = $5, inclusive = $6, version = version + 1
+		WHERE id = $7 AND version = $8
+		RETURNING version`
+
+	args := []interface{}{rule.Name, rule.Country, rule.Region, rule.Category, rule.RateBPS, rule.Inclusive,
+		rule.ID, rule.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&rule.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return taxRuleWriteError(err)
+		}
+	}
+
+	return nil
+}
+
+func (m TaxRuleModel) Delete(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM tax_rules WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Quote prices the lines for a destination with the tax of the best matching rule for every line.
+// Every line only needs ProductID and Quantity set. If one of the products doesn't exist
+// ErrRecordNotFound is returned.
+func (m TaxRuleModel) Quote(country, region string, lines []*QuoteLine) (*Quote, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	ids := make([]int, len(lines))
+	for i, line := range lines {
+		ids[i] = line.ProductID
+	}
+
+	query := `
+		SELECT product_id, product_name, category, price
+		FROM products
+		WHERE product_id = ANY($1)`
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	products := make(map[int]*Product, len(lines))
+
+	for rows.Next() {
+		var product Product
+
+		if err := rows.Scan(&product.ID, &product.Name, &product.Category, &product.Price); err != nil {
+			return nil, err
+		}
+
+		products[product.ID] = &product
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT id, name, country, region, category, rate_bps, inclusive
+		FROM tax_rules
+		WHERE country = $1 OR country = $2`
+
+	ruleRows, err := m.DB.QueryContext(ctx, query, country, tax.Everywhere)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := ruleRows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	var rules []*tax.Rule
+
+	for ruleRows.Next() {
+		var rule tax.Rule
+
+		err := ruleRows.Scan(&rule.ID, &rule.Name, &rule.Country, &rule.Region, &rule.Category, &rule.RateBPS, &rule.Inclusive)
+		if err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, &rule)
+	}
+	if err = ruleRows.Err(); err != nil {
+		return nil, err
+	}
+
+	quote := &Quote{
+		Country:  country,
+		Region:   region,
+		Currency: DefaultCurrency,
+		Lines:    lines,
+	}
+
+	for _, line := range lines {
+		product, ok := products[line.ProductID]
+		if !ok {
+			return nil, ErrRecordNotFound
+		}
+
+		line.Name = product.Name
+		line.Category = product.Category
+		line.UnitPrice = product.Price.Amount
+
+		rule := tax.Match(rules, country, region, product.Category)
+		taxed := tax.Calculate(rule, line.UnitPrice*int64(line.Quantity))
+
+		line.Net, line.Tax, line.Gross = taxed.Net, taxed.Tax, taxed.Gross
+
+		if rule != nil {
+			line.TaxRateBPS = rule.RateBPS
+			line.TaxRule = rule.Name
+			line.TaxInclusive = rule.Inclusive
+		}
+
+		quote.Net += line.Net
+		quote.Tax += line.Tax
+		quote.Gross += line.Gross
+	}
+
+	return quote, nil
+}
+
+func taxRuleWriteError(err error) error {
+	switch {
+	case err.Error() == `pq: duplicate key value violates unique constraint "tax_rules_destination_idx"`:
+		return ErrDuplicateTaxRule
+	default:
+		return err
+	}
+}
@@ -3,11 +3,11 @@ package model
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 
 	"database/sql"
 	"encoding/base32"
-
-	// "errors"
+	"errors"
 
 	"log"
 	"time"
@@ -18,14 +18,39 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeRefresh        = "refresh"
+)
+
+var (
+	// ErrTokenReused is returned when a refresh token which was already rotated is used again.
+	// This means the token was stolen, so every token of its family has been revoked.
+	ErrTokenReused = errors.New("refresh token reused")
 )
 
 type (
 	Token struct {
 		Plaintext string    `json:"token"`
+		Hash      []byte    `json:"-"`
 		UserID    int64     `json:"-"`
 		Expiry    time.Time `json:"expiry"`
 		Scope     string    `json:"-"`
+		FamilyID  int64     `json:"-"`
+		UserAgent string    `json:"-"`
+		IP        string    `json:"-"`
+	}
+
+	// Session is an active login of a user, described by the client its tokens were last issued
+	// to. CreatedAt is the time of the login and LastUsedAt the time its refresh token was last
+	// rotated, if ever. The tokens themselves are never part of it.
+	Session struct {
+		ID         int64      `json:"id"`
+		CreatedAt  time.Time  `json:"created_at"`
+		LastUsedAt *time.Time `json:"last_used_at"`
+		Expiry     time.Time  `json:"expiry"`
+		UserAgent  string     `json:"user_agent"`
+		IP         string     `json:"ip"`
+		Current    bool       `json:"current"`
 	}
 
 	TokenModel struct {
@@ -46,18 +71,162 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 
 }
 
-func (m TokenModel) Insert(token *Token) error {
+// NewSession logs a user in by issuing a short-lived authentication token together with a
+// long-lived refresh token, which can be exchanged for new tokens with Rotate. Both start a new
+// token family, and record the user agent and IP address of the client they were issued to.
+func (m TokenModel) NewSession(userID int64, accessTTL, refreshTTL time.Duration, userAgent, ip string) (*Token, *Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var familyID int64
+
+	err = tx.QueryRowContext(ctx, `SELECT nextval('token_families_seq')`).Scan(&familyID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	access, refresh, err := insertTokenPair(ctx, tx, userID, familyID, accessTTL, refreshTTL, userAgent, ip)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, nil
+}
+
+// Rotate exchanges a refresh token for a new authentication and refresh token of the same
+// family. The new tokens keep the created_at of the login and record the rotation in
+// last_used_at. The old refresh token is kept as used rather than deleted, so that if it's ever
+// presented again the whole family is revoked and ErrTokenReused is returned. ErrRecordNotFound
+// is returned for unknown and expired refresh tokens.
+func (m TokenModel) Rotate(refreshPlaintext string, accessTTL, refreshTTL time.Duration, userAgent, ip string) (*Token, *Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
 	query := `
-		INSERT INTO tokens (plaintext, users_id, expiry, scope)
-		VALUES ($1, $2, $3, $4)
+		SELECT users_id, family_id, created_at, expiry, used_at IS NOT NULL
+		FROM tokens
+		WHERE hash = $1 AND scope = $2
+		FOR UPDATE`
+
+	var (
+		userID    int64
+		familyID  int64
+		createdAt time.Time
+		expiry    time.Time
+		used      bool
+	)
+
+	err = tx.QueryRowContext(ctx, query, tokenHash(refreshPlaintext), ScopeRefresh).Scan(&userID, &familyID, &createdAt, &expiry, &used)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	if used {
+		_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family_id = $1`, familyID)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if err = tx.Commit(); err != nil {
+			return nil, nil, err
+		}
+
+		return nil, nil, ErrTokenReused
+	}
+
+	if !expiry.After(time.Now()) {
+		return nil, nil, ErrRecordNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE tokens SET used_at = NOW() WHERE hash = $1`, tokenHash(refreshPlaintext))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	access, refresh, err := insertTokenPair(ctx, tx, userID, familyID, accessTTL, refreshTTL, userAgent, ip)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	query = `
+		UPDATE tokens
+		SET created_at = $1, last_used_at = NOW()
+		WHERE hash IN ($2, $3)
 		`
 
-	args := []interface{}{token.Plaintext, token.UserID, token.Expiry, token.Scope}
+	_, err = tx.ExecContext(ctx, query, createdAt, access.Hash, refresh.Hash)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, nil
+}
+
+// insertTokenPair issues an authentication and a refresh token of the given family.
+func insertTokenPair(ctx context.Context, tx *sql.Tx, userID, familyID int64, accessTTL, refreshTTL time.Duration, userAgent, ip string) (*Token, *Token, error) {
+	access, err := generateToken(userID, accessTTL, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
 
+	refresh, err := generateToken(userID, refreshTTL, ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, token := range []*Token{access, refresh} {
+		token.FamilyID = familyID
+		token.UserAgent = userAgent
+		token.IP = ip
+
+		_, err = tx.ExecContext(ctx, insertTokenSQL, token.args()...)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return access, refresh, nil
+}
+
+const insertTokenSQL = `
+		INSERT INTO tokens (hash, users_id, expiry, scope, family_id, user_agent, ip)
+		VALUES ($1, $2, $3, $4, NULLIF($5, 0), $6, $7)
+		`
+
+func (t *Token) args() []interface{} {
+	return []interface{}{t.Hash, t.UserID, t.Expiry, t.Scope, t.FamilyID, t.UserAgent, t.IP}
+}
+
+func (m TokenModel) Insert(token *Token) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, args...)
+	_, err := m.DB.ExecContext(ctx, insertTokenSQL, token.args()...)
 	return err
 }
 
@@ -74,6 +243,94 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	return err
 }
 
+// Delete revokes a token together with the other tokens of its family, so that logging out also
+// revokes the refresh token of the login. ErrRecordNotFound is returned if it doesn't exist.
+func (m TokenModel) Delete(scope, tokenPlaintext string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		DELETE FROM tokens
+		WHERE scope = $1 AND hash = $2
+		RETURNING family_id
+		`
+
+	var familyID sql.NullInt64
+
+	err = tx.QueryRowContext(ctx, query, scope, tokenHash(tokenPlaintext)).Scan(&familyID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if familyID.Valid {
+		_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family_id = $1`, familyID.Int64)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetSessionsForUser returns the active logins of a user, newest first: the current refresh token
+// of every token family, and the authentication tokens which don't belong to a family. The
+// session the authentication token currentPlaintext belongs to is marked as the current one.
+func (m TokenModel) GetSessionsForUser(userID int64, currentPlaintext string) ([]*Session, error) {
+	query := `
+		SELECT id, created_at, last_used_at, expiry, user_agent, ip,
+			hash = $2 OR COALESCE(family_id = (SELECT family_id FROM tokens WHERE hash = $2), false)
+		FROM tokens
+		WHERE users_id = $1 AND expiry > NOW() AND used_at IS NULL
+			AND (scope = $3 OR (scope = $4 AND family_id IS NULL))
+		ORDER BY created_at DESC, id DESC
+		`
+
+	args := []interface{}{userID, tokenHash(currentPlaintext), ScopeRefresh, ScopeAuthentication}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+		var session Session
+
+		err := rows.Scan(&session.ID, &session.CreatedAt, &session.LastUsedAt, &session.Expiry, &session.UserAgent, &session.IP, &session.Current)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
 	token := &Token{
 		UserID: userID,
@@ -89,10 +346,18 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 	}
 
 	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	token.Hash = tokenHash(token.Plaintext)
 
 	return token, nil
 }
 
+// tokenHash returns the SHA-256 hash of a token. Only the hash is stored, so the plaintext of a
+// token is only known to the client it was issued to.
+func tokenHash(tokenPlaintext string) []byte {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+	return hash[:]
+}
+
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 	v.Check(tokenPlaintext != "", "token", "must be provided")
 	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
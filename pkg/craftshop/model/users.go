@@ -58,6 +58,11 @@ func (p *password) Set(plaintextPassword string) error {
 // hashed password stored in the struct, returning true if it matches and false
 // otherwise.
 func (p *password) Matches(plaintextPassword string) (bool, error) {
+	// A password which has never been set, e.g. of a seller without a password, matches nothing.
+	if len(p.hash) == 0 {
+		return false, nil
+	}
+
 	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
 	if err != nil {
 		switch {
@@ -219,12 +224,12 @@ func (u UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		FROM	users
         INNER JOIN tokens
 			ON users.id = tokens.users_id
-        WHERE tokens.plaintext = $1 
+        WHERE tokens.hash = $1 
 			AND tokens.scope = $2
 			AND tokens.expiry > $3
 		`
 
-	args := []interface{}{tokenPlaintext, tokenScope, time.Now()}
+	args := []interface{}{tokenHash(tokenPlaintext), tokenScope, time.Now()}
 
 	var user User
 
@@ -0,0 +1,297 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/validator"
+	"github.com/ainelnazaraly/CraftShop/pkg/money"
+	"github.com/lib/pq"
+)
+
+// ProductOption is one dimension in which the variants of a product differ, e.g. a "size" option
+// with the values S, M and L.
+type ProductOption struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// ProductVariant is a sellable version of a product with its own SKU and stock. Options holds
+// one value for every option of the product. A nil Price means that the variant is sold at the
+// price of the product.
+type ProductVariant struct {
+	ID             int64             `json:"id"`
+	ProductID      int               `json:"product_id"`
+	SKU            string            `json:"sku"`
+	Options        map[string]string `json:"options"`
+	Price          *money.Money      `json:"price,omitempty"`
+	ConvertedPrice *ConvertedPrice   `json:"converted_price,omitempty"`
+	Stock          int               `json:"stock"`
+}
+
+var (
+	// ErrDuplicateSKU is returned when a variant uses a SKU which already belongs to a variant of
+	// another product.
+	ErrDuplicateSKU = errors.New("duplicate sku")
+)
+
+// validateProductVariants checks the options schema of a product and that every variant fits it.
+func validateProductVariants(v *validator.Validator, product *Product) {
+	v.Check(len(product.Options) <= 5, "options", "must not contain more than 5 options")
+	v.Check(len(product.Variants) <= 100, "variants", "must not contain more than 100 variants")
+
+	values := make(map[string][]string, len(product.Options))
+
+	for i, option := range product.Options {
+		key := fmt.Sprintf("options[%d]", i)
+
+		if option == nil {
+			v.AddError(key, "must not be null")
+			continue
+		}
+
+		v.Check(option.Name != "", key+".name", "must be provided")
+		v.Check(len(option.Name) <= 50, key+".name", "must not be more than 50 bytes long")
+		v.Check(values[option.Name] == nil, key+".name", "must not be duplicated")
+		v.Check(len(option.Values) > 0, key+".values", "must contain at least one value")
+		v.Check(validator.Unique(option.Values), key+".values", "must not contain duplicate values")
+
+		values[option.Name] = option.Values
+	}
+
+	skus := make(map[string]bool, len(product.Variants))
+	combinations := make(map[string]bool, len(product.Variants))
+
+	for i, variant := range product.Variants {
+		key := fmt.Sprintf("variants[%d]", i)
+
+		if variant == nil {
+			v.AddError(key, "must not be null")
+			continue
+		}
+
+		v.Check(variant.SKU != "", key+".sku", "must be provided")
+		v.Check(len(variant.SKU) <= 64, key+".sku", "must not be more than 64 bytes long")
+		v.Check(!skus[strings.ToLower(variant.SKU)], key+".sku", "must not be duplicated")
+		skus[strings.ToLower(variant.SKU)] = true
+
+		if variant.Price != nil {
+			v.Check(variant.Price.Amount > 0, key+".price", "must be greater than zero")
+			v.Check(variant.Price.Amount <= maxPrice, key+".price", "must not be more than 99999999.99")
+			v.Check(variant.Price.Currency == product.Price.Currency, key+".price", "must be in the currency of the product")
+		}
+		v.Check(variant.Stock >= 0, key+".stock", "must not be negative")
+
+		v.Check(len(variant.Options) == len(product.Options), key+".options", "must contain a value for every option of the product")
+		for name, value := range variant.Options {
+			v.Check(validator.In(value, values[name]...), key+".options."+name, "must be one of the values of the option")
+		}
+
+		combination := variantCombination(variant.Options)
+		v.Check(!combinations[combination], key+".options", "must not be the same as the options of another variant")
+		combinations[combination] = true
+	}
+}
+
+// variantCombination returns a canonical string for a set of option values, so that variants with
+// the same options can be detected.
+func variantCombination(options map[string]string) string {
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%q=%q;", name, options[name])
+	}
+	return b.String()
+}
+
+// GetVariant returns the variant with the given SKU. SKUs are case-insensitive.
+func (p ProductModel) GetVariant(sku string) (*ProductVariant, error) {
+	query := `
+		SELECT id, product_id, sku, options, price, stock
+		FROM product_variants
+		WHERE sku = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	variant, err := scanProductVariant(p.DB.QueryRowContext(ctx, query, sku))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return variant, nil
+}
+
+// loadProductVariants reads the options schema and the variants of a product.
+func (p ProductModel) loadProductVariants(ctx context.Context, prod *Product) error {
+	query := `
+		SELECT name, option_values
+		FROM product_options
+		WHERE product_id = $1
+		ORDER BY position`
+
+	rows, err := p.DB.QueryContext(ctx, query, prod.ID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			p.ErrorLog.Println(err)
+		}
+	}()
+
+	prod.Options = []*ProductOption{}
+
+	for rows.Next() {
+		var option ProductOption
+
+		if err := rows.Scan(&option.Name, pq.Array(&option.Values)); err != nil {
+			return err
+		}
+
+		prod.Options = append(prod.Options, &option)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	query = `
+		SELECT id, product_id, sku, options, price, stock
+		FROM product_variants
+		WHERE product_id = $1
+		ORDER BY id`
+
+	variantRows, err := p.DB.QueryContext(ctx, query, prod.ID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := variantRows.Close(); err != nil {
+			p.ErrorLog.Println(err)
+		}
+	}()
+
+	prod.Variants = []*ProductVariant{}
+
+	for variantRows.Next() {
+		variant, err := scanProductVariant(variantRows)
+		if err != nil {
+			return err
+		}
+
+		prod.Variants = append(prod.Variants, variant)
+	}
+
+	return variantRows.Err()
+}
+
+// saveProductVariants replaces the options schema of a product and synchronises its variants
+// with prod.Variants: variants are matched by SKU, so existing variants keep their ids, and
+// variants which are no longer listed are deleted.
+func saveProductVariants(ctx context.Context, tx *sql.Tx, prod *Product) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM product_options WHERE product_id = $1`, prod.ID)
+	if err != nil {
+		return err
+	}
+
+	for position, option := range prod.Options {
+		query := `
+			INSERT INTO product_options (product_id, name, option_values, position)
+			VALUES ($1, $2, $3, $4)`
+
+		_, err := tx.ExecContext(ctx, query, prod.ID, option.Name, pq.Array(option.Values), position)
+		if err != nil {
+			return err
+		}
+	}
+
+	skus := make([]string, len(prod.Variants))
+	for i, variant := range prod.Variants {
+		skus[i] = variant.SKU
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM product_variants WHERE product_id = $1 AND NOT (sku = ANY($2::citext[]))`, prod.ID, pq.Array(skus))
+	if err != nil {
+		return err
+	}
+
+	for _, variant := range prod.Variants {
+		options, err := json.Marshal(variant.Options)
+		if err != nil {
+			return err
+		}
+
+		// A SKU which belongs to another product matches the conflict clause but not its WHERE,
+		// so no row is returned.
+		query := `
+			INSERT INTO product_variants (product_id, sku, options, price, stock)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (sku) DO UPDATE
+				SET sku = EXCLUDED.sku, options = EXCLUDED.options, price = EXCLUDED.price, stock = EXCLUDED.stock
+				WHERE product_variants.product_id = EXCLUDED.product_id
+			RETURNING id`
+
+		args := []interface{}{prod.ID, variant.SKU, options, variant.Price, variant.Stock}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&variant.ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrDuplicateSKU
+			default:
+				return err
+			}
+		}
+
+		variant.ProductID = prod.ID
+	}
+
+	return nil
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanProductVariant(row rowScanner) (*ProductVariant, error) {
+	var variant ProductVariant
+	var options []byte
+	var price sql.NullString
+
+	err := row.Scan(&variant.ID, &variant.ProductID, &variant.SKU, &options, &price, &variant.Stock)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(options, &variant.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	if price.Valid {
+		variantPrice, err := money.Parse(price.String, money.DefaultCurrency)
+		if err != nil {
+			return nil, err
+		}
+		variant.Price = &variantPrice
+	}
+
+	return &variant, nil
+}
@@ -0,0 +1,519 @@
+package model
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ainelnazaraly/CraftShop/pkg/craftshop/validator"
+	"github.com/ainelnazaraly/CraftShop/pkg/money"
+	"github.com/lib/pq"
+)
+
+// Wishlist is a named list of products a user saved for later. A wishlist with a ShareToken can
+// be viewed by anyone who has the token.
+type Wishlist struct {
+	ID         int64           `json:"id"`
+	UserID     int64           `json:"-"`
+	Name       string          `json:"name"`
+	ShareToken string          `json:"share_token,omitempty"`
+	ItemCount  int             `json:"item_count"`
+	Items      []*WishlistItem `json:"items,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+	Version    int             `json:"-"`
+}
+
+// WishlistItem is a product saved in a wishlist. SavedPrice is the price of the product when it
+// was saved and PriceChange the difference between its current Price and SavedPrice.
+type WishlistItem struct {
+	ProductID   int         `json:"product_id"`
+	Name        string      `json:"product_name"`
+	Price       money.Money `json:"price"`
+	SavedPrice  money.Money `json:"saved_price"`
+	PriceChange money.Money `json:"price_change"`
+	AddedAt     time.Time   `json:"added_at"`
+}
+
+// FavouriteSeller is a seller a user saved for later.
+type FavouriteSeller struct {
+	SellerID   int       `json:"seller_id"`
+	SellerName string    `json:"seller_name"`
+	Location   string    `json:"location"`
+	AddedAt    time.Time `json:"added_at"`
+}
+
+var (
+	// ErrDuplicateWishlist is returned when a user already has a wishlist with the same name.
+	ErrDuplicateWishlist = errors.New("duplicate wishlist")
+)
+
+type WishlistModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateWishlist(v *validator.Validator, wishlist *Wishlist) {
+	v.Check(wishlist.Name != "", "name", "must be provided")
+	v.Check(len(wishlist.Name) <= 100, "name", "must not be more than 100 characters long")
+}
+
+// NewShareToken returns a random token for sharing a wishlist.
+func NewShareToken() (string, error) {
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes), nil
+}
+
+func (m WishlistModel) Insert(wishlist *Wishlist) error {
+	query := `
+		INSERT INTO wishlists (user_id, name)
+		VALUES ($1, $2)
+		RETURNING id, created_at, updated_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, wishlist.UserID, wishlist.Name).Scan(
+		&wishlist.ID, &wishlist.CreatedAt, &wishlist.UpdatedAt, &wishlist.Version,
+	)
+	if err != nil {
+		return wishlistWriteError(err)
+	}
+
+	wishlist.Items = []*WishlistItem{}
+
+	return nil
+}
+
+// wishlistColumns are the columns read by scanWishlist.
+const wishlistColumns = `
+		wishlists.id, wishlists.user_id, wishlists.name, COALESCE(wishlists.share_token, ''),
+		(SELECT count(*) FROM wishlist_items WHERE wishlist_items.wishlist_id = wishlists.id),
+		wishlists.created_at, wishlists.updated_at, wishlists.version`
+
+func scanWishlist(row rowScanner) (*Wishlist, error) {
+	var wishlist Wishlist
+
+	err := row.Scan(&wishlist.ID, &wishlist.UserID, &wishlist.Name, &wishlist.ShareToken, &wishlist.ItemCount,
+		&wishlist.CreatedAt, &wishlist.UpdatedAt, &wishlist.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	return &wishlist, nil
+}
+
+// Get returns the wishlist with the given ID and its items.
+func (m WishlistModel) Get(id int64) (*Wishlist, error) {
+	return m.get(`wishlists.id = $1`, id)
+}
+
+// GetByShareToken returns the wishlist shared with the given token and its items.
+func (m WishlistModel) GetByShareToken(token string) (*Wishlist, error) {
+	return m.get(`wishlists.share_token = $1`, token)
+}
+
+func (m WishlistModel) get(where string, arg interface{}) (*Wishlist, error) {
+	query := `SELECT ` + wishlistColumns + `
+		FROM wishlists
+		WHERE ` + where
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	wishlist, err := scanWishlist(m.DB.QueryRowContext(ctx, query, arg))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	query = `
+		SELECT products.product_id, products.product_name, products.price, wishlist_items.saved_price, wishlist_items.added_at
+		FROM wishlist_items
+		INNER JOIN products ON products.product_id = wishlist_items.product_id
+		WHERE wishlist_items.wishlist_id = $1
+		ORDER BY wishlist_items.added_at DESC, products.product_id`
+
+	rows, err := m.DB.QueryContext(ctx, query, wishlist.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	wishlist.Items = []*WishlistItem{}
+
+	for rows.Next() {
+		var item WishlistItem
+
+		err := rows.Scan(&item.ProductID, &item.Name, &item.Price, &item.SavedPrice, &item.AddedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		item.PriceChange = item.Price.Sub(item.SavedPrice)
+		wishlist.Items = append(wishlist.Items, &item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return wishlist, nil
+}
+
+// GetAllForUser returns the wishlists of a user without their items.
+func (m WishlistModel) GetAllForUser(userID int64) ([]*Wishlist, error) {
+	query := `SELECT ` + wishlistColumns + `
+		FROM wishlists
+		WHERE wishlists.user_id = $1
+		ORDER BY wishlists.created_at, wishlists.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	wishlists := []*Wishlist{}
+
+	for rows.Next() {
+		wishlist, err := scanWishlist(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		wishlists = append(wishlists, wishlist)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return wishlists, nil
+}
+
+// Update saves the name and share token of a wishlist. An empty ShareToken stops sharing it.
+func (m WishlistModel) Update(wishlist *Wishlist) error {
+	query := `
+		UPDATE wishlists
+		SET name = $1, share_token = NULLIF($2, ''), updated_at = NOW(), version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING updated_at, version`
+
+	args := []interface{}{wishlist.Name, wishlist.ShareToken, wishlist.ID, wishlist.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&wishlist.UpdatedAt, &wishlist.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return wishlistWriteError(err)
+		}
+	}
+
+	return nil
+}
+
+// Delete removes a wishlist and its items.
+func (m WishlistModel) Delete(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	productIDs, err := queryIDs(ctx, tx, m.ErrorLog, `SELECT product_id FROM wishlist_items WHERE wishlist_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM wishlists WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = refreshFavouritedCounts(ctx, tx, productIDs...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AddItem saves a product in a wishlist at its current price. Saving a product which is already
+// in the wishlist keeps the price it was first saved at. ErrRecordNotFound is returned if the
+// product doesn't exist.
+func (m WishlistModel) AddItem(wishlistID int64, productID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO wishlist_items (wishlist_id, product_id, saved_price)
+		SELECT $1, product_id, price
+		FROM products
+		WHERE product_id = $2
+		ON CONFLICT (wishlist_id, product_id) DO NOTHING`
+
+	result, err := tx.ExecContext(ctx, query, wishlistID, productID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		var exists bool
+
+		err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM products WHERE product_id = $1)`, productID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			return ErrRecordNotFound
+		}
+
+		return nil
+	}
+
+	err = touchWishlist(ctx, tx, wishlistID)
+	if err != nil {
+		return err
+	}
+
+	err = refreshFavouritedCounts(ctx, tx, int64(productID))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemoveItem removes a product from a wishlist. ErrRecordNotFound is returned if the product
+// isn't in the wishlist.
+func (m WishlistModel) RemoveItem(wishlistID int64, productID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM wishlist_items WHERE wishlist_id = $1 AND product_id = $2`, wishlistID, productID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = touchWishlist(ctx, tx, wishlistID)
+	if err != nil {
+		return err
+	}
+
+	err = refreshFavouritedCounts(ctx, tx, int64(productID))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetFavouriteSellers returns the sellers a user saved, most recently saved first.
+func (m WishlistModel) GetFavouriteSellers(userID int64) ([]*FavouriteSeller, error) {
+	query := `
+		SELECT sellers.seller_id, sellers.seller_name, COALESCE(sellers.location, ''), favourite_sellers.added_at
+		FROM favourite_sellers
+		INNER JOIN sellers ON sellers.seller_id = favourite_sellers.seller_id
+		WHERE favourite_sellers.user_id = $1
+		ORDER BY favourite_sellers.added_at DESC, sellers.seller_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	sellers := []*FavouriteSeller{}
+
+	for rows.Next() {
+		var seller FavouriteSeller
+
+		err := rows.Scan(&seller.SellerID, &seller.SellerName, &seller.Location, &seller.AddedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		sellers = append(sellers, &seller)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sellers, nil
+}
+
+// AddFavouriteSeller saves a seller for a user. Saving a seller twice is not an error.
+// ErrRecordNotFound is returned if the seller doesn't exist.
+func (m WishlistModel) AddFavouriteSeller(userID int64, sellerID int) error {
+	query := `
+		INSERT INTO favourite_sellers (user_id, seller_id)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id, seller_id) DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, sellerID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: insert or update on table "favourite_sellers" violates foreign key constraint "favourite_sellers_seller_id_fkey"`:
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RemoveFavouriteSeller removes a saved seller of a user. ErrRecordNotFound is returned if the
+// user hadn't saved the seller.
+func (m WishlistModel) RemoveFavouriteSeller(userID int64, sellerID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM favourite_sellers WHERE user_id = $1 AND seller_id = $2`, userID, sellerID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// touchWishlist records that the items of a wishlist changed.
+func touchWishlist(ctx context.Context, tx *sql.Tx, wishlistID int64) error {
+	_, err := tx.ExecContext(ctx, `UPDATE wishlists SET updated_at = NOW() WHERE id = $1`, wishlistID)
+	return err
+}
+
+// refreshFavouritedCounts recalculates the number of users who saved each of the products.
+func refreshFavouritedCounts(ctx context.Context, tx *sql.Tx, productIDs ...int64) error {
+	if len(productIDs) == 0 {
+		return nil
+	}
+
+	// Lock the products first, in a consistent order to avoid deadlocks. Under READ COMMITTED the
+	// count below then runs in a statement which sees the items committed by every transaction
+	// which held the lock before; counting in the locking statement would miss them.
+	query := `
+		SELECT product_id
+		FROM products
+		WHERE product_id = ANY($1)
+		ORDER BY product_id
+		FOR UPDATE`
+
+	_, err := tx.ExecContext(ctx, query, pq.Array(productIDs))
+	if err != nil {
+		return err
+	}
+
+	query = `
+		UPDATE products
+		SET favourited_count = (
+			SELECT COUNT(DISTINCT wishlists.user_id)
+			FROM wishlist_items
+			INNER JOIN wishlists ON wishlists.id = wishlist_items.wishlist_id
+			WHERE wishlist_items.product_id = products.product_id
+		)
+		WHERE product_id = ANY($1)`
+
+	_, err = tx.ExecContext(ctx, query, pq.Array(productIDs))
+	return err
+}
+
+func wishlistWriteError(err error) error {
+	switch {
+	case err.Error() == `pq: duplicate key value violates unique constraint "wishlists_user_name_idx"`:
+		return ErrDuplicateWishlist
+	default:
+		return err
+	}
+}
@@ -0,0 +1,244 @@
+// Package ledger implements a double-entry journal in PostgreSQL. Every posted entry consists of
+// lines on named accounts whose amounts sum to zero. Amounts are in minor units of the currency;
+// they are positive for credits and negative for debits, so the balance of an account is the sum
+// of its lines.
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// Accounts of the marketplace itself.
+const (
+	// AccountClearing holds the money collected from buyers.
+	AccountClearing = "marketplace:clearing"
+	// AccountCommission holds the commission the marketplace earns on sales.
+	AccountCommission = "marketplace:commission"
+)
+
+// SellerPayable returns the account of what the marketplace owes a seller.
+func SellerPayable(sellerID int) string {
+	return fmt.Sprintf("seller:%d:payable", sellerID)
+}
+
+// SellerPaidOut returns the account of what has been paid out to a seller.
+func SellerPaidOut(sellerID int) string {
+	return fmt.Sprintf("seller:%d:paid_out", sellerID)
+}
+
+// ErrorLog receives errors which can't be returned to the caller, e.g. from closing query rows.
+var ErrorLog = log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+
+var (
+	// ErrUnbalanced is returned when the lines of an entry don't sum to zero.
+	ErrUnbalanced = errors.New("ledger: entry is not balanced")
+	// ErrDuplicateEntry is returned when an entry with the same reference has already been posted.
+	// References make posting idempotent.
+	ErrDuplicateEntry = errors.New("ledger: duplicate entry")
+	// ErrEntryNotFound is returned when an entry to reverse doesn't exist.
+	ErrEntryNotFound = errors.New("ledger: entry not found")
+)
+
+// Line is the change of one account in an entry.
+type Line struct {
+	Account string `json:"account"`
+	Amount  int64  `json:"amount"`
+}
+
+// Entry is a balanced set of lines. Reference identifies the business event which caused the
+// entry, e.g. "sale:purchase:12", and is unique.
+type Entry struct {
+	ID          int64     `json:"id"`
+	Reference   string    `json:"reference"`
+	Description string    `json:"description"`
+	Currency    string    `json:"currency"`
+	Lines       []Line    `json:"lines"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// StatementLine is a line of an account statement with the balance of the account after it.
+type StatementLine struct {
+	EntryID     int64     `json:"entry_id"`
+	Reference   string    `json:"reference"`
+	Description string    `json:"description"`
+	Amount      int64     `json:"amount"`
+	Balance     int64     `json:"balance"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Querier is implemented by *sql.DB and *sql.Tx.
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Post writes a balanced entry inside the caller's transaction, so that the entry is only kept if
+// the business change which caused it is committed as well. Lines with a zero amount are dropped.
+func Post(ctx context.Context, tx *sql.Tx, entry *Entry) error {
+	var lines []Line
+	var sum int64
+
+	for _, line := range entry.Lines {
+		if line.Amount == 0 {
+			continue
+		}
+		if line.Account == "" {
+			return fmt.Errorf("ledger: line without account in entry %q", entry.Reference)
+		}
+		lines = append(lines, line)
+		sum += line.Amount
+	}
+
+	if len(lines) < 2 || sum != 0 {
+		return ErrUnbalanced
+	}
+
+	entry.Lines = lines
+
+	query := `
+		INSERT INTO journal_entries (reference, description, currency)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (reference) DO NOTHING
+		RETURNING id, created_at`
+
+	err := tx.QueryRowContext(ctx, query, entry.Reference, entry.Description, entry.Currency).Scan(&entry.ID, &entry.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrDuplicateEntry
+		default:
+			return err
+		}
+	}
+
+	for _, line := range entry.Lines {
+		query := `
+			INSERT INTO journal_lines (entry_id, account, amount)
+			VALUES ($1, $2, $3)`
+
+		_, err := tx.ExecContext(ctx, query, entry.ID, line.Account, line.Amount)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reverse posts an entry with reference which undoes the entry with the reference original.
+func Reverse(ctx context.Context, tx *sql.Tx, original, reference, description string) error {
+	query := `
+		SELECT journal_entries.currency, journal_lines.account, journal_lines.amount
+		FROM journal_entries
+		INNER JOIN journal_lines ON journal_lines.entry_id = journal_entries.id
+		WHERE journal_entries.reference = $1
+		ORDER BY journal_lines.id`
+
+	rows, err := tx.QueryContext(ctx, query, original)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			ErrorLog.Println(err)
+		}
+	}()
+
+	entry := &Entry{
+		Reference:   reference,
+		Description: description,
+	}
+
+	for rows.Next() {
+		var line Line
+
+		err := rows.Scan(&entry.Currency, &line.Account, &line.Amount)
+		if err != nil {
+			return err
+		}
+
+		line.Amount = -line.Amount
+		entry.Lines = append(entry.Lines, line)
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if len(entry.Lines) == 0 {
+		return ErrEntryNotFound
+	}
+
+	return Post(ctx, tx, entry)
+}
+
+// Balance returns the sum of all lines of an account.
+func Balance(ctx context.Context, q Querier, account string) (int64, error) {
+	var balance int64
+
+	err := q.QueryRowContext(ctx, `SELECT COALESCE(SUM(amount), 0) FROM journal_lines WHERE account = $1`, account).Scan(&balance)
+	return balance, err
+}
+
+// Statement returns the balance of an account before from and its lines posted in [from, to),
+// each with the running balance after it.
+func Statement(ctx context.Context, q Querier, account string, from, to time.Time) (int64, []*StatementLine, error) {
+	query := `
+		SELECT COALESCE(SUM(journal_lines.amount), 0)
+		FROM journal_lines
+		INNER JOIN journal_entries ON journal_entries.id = journal_lines.entry_id
+		WHERE journal_lines.account = $1 AND journal_entries.created_at < $2`
+
+	var opening int64
+
+	err := q.QueryRowContext(ctx, query, account, from).Scan(&opening)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	query = `
+		SELECT journal_entries.id, journal_entries.reference, journal_entries.description, journal_lines.amount,
+			journal_entries.created_at
+		FROM journal_lines
+		INNER JOIN journal_entries ON journal_entries.id = journal_lines.entry_id
+		WHERE journal_lines.account = $1 AND journal_entries.created_at >= $2 AND journal_entries.created_at < $3
+		ORDER BY journal_entries.created_at, journal_lines.id`
+
+	rows, err := q.QueryContext(ctx, query, account, from, to)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			ErrorLog.Println(err)
+		}
+	}()
+
+	balance := opening
+	lines := []*StatementLine{}
+
+	for rows.Next() {
+		var line StatementLine
+
+		err := rows.Scan(&line.EntryID, &line.Reference, &line.Description, &line.Amount, &line.CreatedAt)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		balance += line.Amount
+		line.Balance = balance
+		lines = append(lines, &line)
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	return opening, lines, nil
+}
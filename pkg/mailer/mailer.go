@@ -0,0 +1,180 @@
+// Package mailer renders the emails the API sends from templates embedded in the binary and
+// delivers them through a Sender, e.g. an SMTP server or, during development, a sink which keeps
+// the messages locally.
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+	"time"
+
+	ttemplate "text/template"
+)
+
+// Template files of the emails the API sends.
+const (
+	TemplateActivation    = "token_activation.tmpl"
+	TemplatePasswordReset = "token_password_reset.tmpl"
+	TemplateWelcome       = "user_welcome.tmpl"
+)
+
+//go:embed "templates"
+var templateFS embed.FS
+
+// Message is a rendered email.
+type Message struct {
+	From      string
+	To        string
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+	Date      time.Time
+}
+
+// Sender delivers messages. Implementations must be safe for concurrent use.
+type Sender interface {
+	Send(msg *Message) error
+}
+
+// Mailer renders emails and hands them to a Sender. A failed delivery is tried again Attempts
+// times in total, waiting RetryDelay between the attempts.
+type Mailer struct {
+	Sender     Sender
+	From       string
+	Attempts   int
+	RetryDelay time.Duration
+}
+
+// New returns a Mailer which sends from the given address, trying each delivery three times.
+func New(sender Sender, from string) *Mailer {
+	return &Mailer{
+		Sender:     sender,
+		From:       from,
+		Attempts:   3,
+		RetryDelay: 500 * time.Millisecond,
+	}
+}
+
+// Send renders the template file with data and sends the email to recipient. The template file
+// must define the "subject", "plainBody" and "htmlBody" templates.
+func (m *Mailer) Send(recipient, templateFile string, data interface{}) error {
+	msg, err := m.render(recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	for i := 1; i <= m.Attempts; i++ {
+		err = m.Sender.Send(msg)
+		if err == nil {
+			return nil
+		}
+
+		if i < m.Attempts {
+			time.Sleep(m.RetryDelay)
+		}
+	}
+
+	return fmt.Errorf("mailer: sending %s to %s: %w", templateFile, recipient, err)
+}
+
+func (m *Mailer) render(recipient, templateFile string, data interface{}) (*Message, error) {
+	// The subject and the plain text body are rendered with text/template, so they aren't
+	// HTML-escaped.
+	textTmpl, err := ttemplate.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	htmlTmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := new(bytes.Buffer)
+	err = textTmpl.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return nil, err
+	}
+
+	plainBody := new(bytes.Buffer)
+	err = textTmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	htmlBody := new(bytes.Buffer)
+	err = htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		From:      m.From,
+		To:        recipient,
+		Subject:   strings.TrimSpace(subject.String()),
+		PlainBody: strings.TrimSpace(plainBody.String()),
+		HTMLBody:  strings.TrimSpace(htmlBody.String()),
+		Date:      time.Now(),
+	}, nil
+}
+
+// Bytes returns the message in the RFC 5322 format, with the plain text and HTML bodies as
+// alternatives.
+func (msg *Message) Bytes() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	mw := multipart.NewWriter(buf)
+
+	header := []string{
+		"From: " + msg.From,
+		"To: " + msg.To,
+		"Subject: " + mime.QEncoding.Encode("utf-8", msg.Subject),
+		"Date: " + msg.Date.Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/alternative; boundary=" + mw.Boundary(),
+	}
+	buf.WriteString(strings.Join(header, "\r\n") + "\r\n\r\n")
+
+	parts := []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=utf-8", msg.PlainBody},
+		{"text/html; charset=utf-8", msg.HTMLBody},
+	}
+
+	for _, part := range parts {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		qw := quotedprintable.NewWriter(w)
+
+		_, err = qw.Write([]byte(part.body))
+		if err != nil {
+			return nil, err
+		}
+
+		err = qw.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := mw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,59 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// File is a Sender for development which writes every message to an .eml file in Dir instead of
+// delivering it. The files can be opened with any mail client.
+type File struct {
+	Dir string
+	seq atomic.Int64
+}
+
+// NewFile returns a File sink writing to dir, creating the directory if it doesn't exist.
+func NewFile(dir string) (*File, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{Dir: dir}, nil
+}
+
+func (f *File) Send(msg *Message) error {
+	data, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%04d.eml", msg.Date.Format("20060102T150405.000000000"), f.seq.Add(1))
+
+	return os.WriteFile(filepath.Join(f.Dir, name), data, 0o600)
+}
+
+// Memory is a Sender for development and tests which keeps the messages in memory.
+type Memory struct {
+	mu       sync.Mutex
+	messages []*Message
+}
+
+func (m *Memory) Send(msg *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages returns the messages sent so far, oldest first.
+func (m *Memory) Messages() []*Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]*Message(nil), m.messages...)
+}
@@ -0,0 +1,226 @@
+// Package money represents amounts of money exactly, as an integer number of minor units (cents)
+// of an ISO 4217 currency, so that sums of prices don't drift the way float64 sums do. Amounts
+// are written as decimal strings with at most two fractional digits, both in JSON and in SQL.
+package money
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is the currency of the prices stored by the shop.
+const DefaultCurrency = "USD"
+
+// maxDigits is the number of integer digits an amount may have, which keeps the amount in minor
+// units well inside an int64.
+const maxDigits = 15
+
+var (
+	// ErrInvalidFormat is returned when an amount is not a decimal number.
+	ErrInvalidFormat = errors.New("must be a decimal number such as \"12.50\"")
+
+	// ErrTooPrecise is returned when an amount has more than two fractional digits.
+	ErrTooPrecise = errors.New("must not have more than two decimal places")
+)
+
+// Money is an amount of minor units of Currency, so USD 12.50 is Money{1250, "USD"}.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+// New returns amount minor units of currency.
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// Parse reads a decimal amount such as "12.5" or "-3.99" of currency. Amounts with more than two
+// fractional digits are rejected with ErrTooPrecise rather than rounded.
+func Parse(s, currency string) (Money, error) {
+	negative := strings.HasPrefix(s, "-")
+	if negative {
+		s = s[1:]
+	}
+
+	whole, fraction, hasPoint := strings.Cut(s, ".")
+
+	if whole == "" || len(whole) > maxDigits || !isDigits(whole) || (hasPoint && !isDigits(fraction)) {
+		return Money{}, ErrInvalidFormat
+	}
+
+	if len(fraction) > 2 {
+		return Money{}, ErrTooPrecise
+	}
+
+	cents := (fraction + "00")[:2]
+
+	amount, err := strconv.ParseInt(whole+cents, 10, 64)
+	if err != nil {
+		return Money{}, ErrInvalidFormat
+	}
+
+	if negative {
+		amount = -amount
+	}
+
+	return New(amount, currency), nil
+}
+
+// isDigits reports whether s is a non-empty string of ASCII digits.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// String returns the amount as a decimal string with two fractional digits, without the currency.
+func (m Money) String() string {
+	amount := m.Amount
+	sign := ""
+
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	return fmt.Sprintf("%s%d.%02d", sign, amount/100, amount%100)
+}
+
+// Add returns the sum of m and other, which must be in the same currency. It panics if the
+// currencies differ.
+func (m Money) Add(other Money) Money {
+	return New(m.Amount+other.Amount, m.currency(other))
+}
+
+// Sub returns m minus other, which must be in the same currency. It panics if the currencies
+// differ.
+func (m Money) Sub(other Money) Money {
+	return New(m.Amount-other.Amount, m.currency(other))
+}
+
+// Mul returns m multiplied by a quantity.
+func (m Money) Mul(quantity int) Money {
+	return New(m.Amount*int64(quantity), m.Currency)
+}
+
+// Convert returns m converted to currency at rate, the number of units of currency paid for one
+// unit of the currency of m. The result is rounded half away from zero to decimals decimal places,
+// the precision of currency, e.g. 0 for JPY. decimals must be between 0 and 2.
+func (m Money) Convert(currency string, rate *big.Rat, decimals int) Money {
+	if decimals < 0 || decimals > 2 {
+		panic(fmt.Sprintf("money: unsupported number of decimal places %d", decimals))
+	}
+
+	// step is the number of hundredths in the smallest unit of currency.
+	step := big.NewInt(int64(math.Pow10(2 - decimals)))
+
+	product := new(big.Rat).Mul(new(big.Rat).SetInt64(m.Amount), rate)
+	denom := new(big.Int).Mul(product.Denom(), step)
+
+	quotient, remainder := new(big.Int).QuoRem(product.Num(), denom, new(big.Int))
+
+	if remainder.Abs(remainder).Lsh(remainder, 1).Cmp(denom) >= 0 {
+		quotient.Add(quotient, big.NewInt(int64(product.Num().Sign())))
+	}
+
+	return New(quotient.Mul(quotient, step).Int64(), currency)
+}
+
+// currency returns the currency of the result of an operation on m and other. An empty currency
+// matches any other, which lets the zero value be used as the start of a sum. Mixing currencies
+// is a programming error, as the result would be meaningless, so it panics.
+func (m Money) currency(other Money) string {
+	switch {
+	case m.Currency == "":
+		return other.Currency
+	case other.Currency != "" && other.Currency != m.Currency:
+		panic(fmt.Sprintf("money: mismatched currencies %s and %s", m.Currency, other.Currency))
+	}
+
+	return m.Currency
+}
+
+// MarshalJSON writes the amount as a JSON string such as "12.50", so that clients parsing JSON
+// numbers as floats don't lose precision.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(m.String())), nil
+}
+
+// UnmarshalJSON reads an amount from a JSON string or number. The currency is kept, or set to
+// DefaultCurrency if it is empty.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := string(data)
+
+	if s == "null" {
+		return nil
+	}
+
+	if strings.HasPrefix(s, `"`) {
+		unquoted, err := strconv.Unquote(s)
+		if err != nil {
+			return ErrInvalidFormat
+		}
+		s = unquoted
+	}
+
+	parsed, err := Parse(s, m.orDefaultCurrency())
+	if err != nil {
+		return err
+	}
+
+	*m = parsed
+	return nil
+}
+
+// Scan reads an amount from a DECIMAL column. The currency is kept, or set to DefaultCurrency if
+// it is empty.
+func (m *Money) Scan(src interface{}) error {
+	var parsed Money
+	var err error
+
+	switch src := src.(type) {
+	case []byte:
+		parsed, err = Parse(string(src), m.orDefaultCurrency())
+	case string:
+		parsed, err = Parse(src, m.orDefaultCurrency())
+	case int64:
+		parsed = New(src*100, m.orDefaultCurrency())
+	case float64:
+		parsed = New(int64(math.Round(src*100)), m.orDefaultCurrency())
+	default:
+		return fmt.Errorf("money: cannot scan %T", src)
+	}
+
+	if err != nil {
+		return fmt.Errorf("money: %w", err)
+	}
+
+	*m = parsed
+	return nil
+}
+
+// Value writes the amount as a decimal string for a DECIMAL column.
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+func (m *Money) orDefaultCurrency() string {
+	if m.Currency == "" {
+		return DefaultCurrency
+	}
+
+	return m.Currency
+}
@@ -0,0 +1,113 @@
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// FakeSignatureHeader is the header carrying the signature of webhooks of the Fake provider.
+const FakeSignatureHeader = "X-Fake-Signature"
+
+const fakeIntentPrefix = "fake_pi_"
+
+// Fake is a Provider for development and tests which never talks to a real gateway. It authorizes
+// every payment up to DeclineAbove (no limit if it is zero) and accepts every capture and refund
+// of its own intents. Fake doesn't send webhooks itself; they can be simulated by posting an Event
+// signed with Sign to the webhook endpoint.
+type Fake struct {
+	Secret       []byte
+	DeclineAbove int64
+}
+
+// NewFake returns a Fake provider whose webhooks are signed with secret.
+func NewFake(secret string) *Fake {
+	return &Fake{Secret: []byte(secret)}
+}
+
+func (f *Fake) Name() string {
+	return "fake"
+}
+
+func (f *Fake) Authorize(ctx context.Context, amount int64, currency, reference string) (string, error) {
+	if amount <= 0 {
+		return "", fmt.Errorf("payments: invalid amount %d", amount)
+	}
+
+	if f.DeclineAbove > 0 && amount > f.DeclineAbove {
+		return "", ErrDeclined
+	}
+
+	b := make([]byte, 12)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return fakeIntentPrefix + hex.EncodeToString(b), nil
+}
+
+func (f *Fake) Capture(ctx context.Context, intentID string, amount int64) error {
+	return f.check(intentID, amount)
+}
+
+func (f *Fake) Refund(ctx context.Context, intentID string, amount int64, idempotencyKey string) error {
+	if idempotencyKey == "" {
+		return errors.New("payments: missing idempotency key")
+	}
+
+	return f.check(intentID, amount)
+}
+
+func (f *Fake) check(intentID string, amount int64) error {
+	if !strings.HasPrefix(intentID, fakeIntentPrefix) {
+		return fmt.Errorf("payments: unknown intent %q", intentID)
+	}
+
+	if amount <= 0 {
+		return fmt.Errorf("payments: invalid amount %d", amount)
+	}
+
+	return nil
+}
+
+// VerifyWebhook expects the hex encoded HMAC-SHA256 of the body in the FakeSignatureHeader header
+// and an Event encoded as JSON in the body.
+func (f *Fake) VerifyWebhook(header http.Header, body []byte) (*Event, error) {
+	signature, err := hex.DecodeString(header.Get(FakeSignatureHeader))
+	if err != nil || !hmac.Equal(signature, f.mac(body)) {
+		return nil, ErrInvalidSignature
+	}
+
+	var event Event
+
+	err = json.Unmarshal(body, &event)
+	if err != nil {
+		return nil, errors.Join(ErrInvalidEvent, err)
+	}
+
+	if event.ID == "" || event.Type == "" || event.IntentID == "" {
+		return nil, ErrInvalidEvent
+	}
+
+	return &event, nil
+}
+
+// Sign returns the signature of a webhook body for the FakeSignatureHeader header.
+func (f *Fake) Sign(body []byte) string {
+	return hex.EncodeToString(f.mac(body))
+}
+
+func (f *Fake) mac(body []byte) []byte {
+	h := hmac.New(sha256.New, f.Secret)
+	h.Write(body)
+	return h.Sum(nil)
+}
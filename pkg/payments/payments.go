@@ -0,0 +1,57 @@
+// Package payments defines the interface the API uses to talk to payment gateways, so that a real
+// gateway can be integrated without touching the handlers. Amounts are always in minor units of
+// the currency, e.g. cents.
+package payments
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Event types delivered through webhooks.
+const (
+	EventAuthorized = "payment.authorized"
+	EventCaptured   = "payment.captured"
+	EventFailed     = "payment.failed"
+	EventRefunded   = "payment.refunded"
+)
+
+var (
+	// ErrDeclined is returned when the gateway refuses to authorize a payment.
+	ErrDeclined = errors.New("payment declined")
+	// ErrInvalidSignature is returned when a webhook request wasn't signed by the gateway.
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	// ErrInvalidEvent is returned when a webhook request doesn't contain a usable event.
+	ErrInvalidEvent = errors.New("invalid webhook event")
+)
+
+// Event is a notification from the gateway about a change of a payment intent. ID is unique per
+// gateway and is used to ignore events which are delivered more than once. Amount is in minor
+// units of Currency.
+type Event struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	IntentID string `json:"intent_id"`
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Provider is a payment gateway. Implementations must be safe for concurrent use.
+type Provider interface {
+	// Name identifies the gateway, e.g. in the provider column of stored payments.
+	Name() string
+	// Authorize reserves amount on the buyer's payment method and returns the id of the payment
+	// intent at the gateway. reference is our own id of the payment.
+	Authorize(ctx context.Context, amount int64, currency, reference string) (string, error)
+	// Capture collects amount of an authorized intent. The gateway confirms it with an
+	// EventCaptured or EventFailed webhook.
+	Capture(ctx context.Context, intentID string, amount int64) error
+	// Refund pays amount of a captured intent back to the buyer. The gateway confirms it with an
+	// EventRefunded webhook. Refunds with the same idempotencyKey are only carried out once, so a
+	// failed refund can safely be requested again.
+	Refund(ctx context.Context, intentID string, amount int64, idempotencyKey string) error
+	// VerifyWebhook checks the signature of a webhook request and returns the event it contains.
+	VerifyWebhook(header http.Header, body []byte) (*Event, error)
+}
@@ -0,0 +1,112 @@
+// Package storage stores uploaded files, such as product images, behind the Storage interface, so
+// that the local filesystem used by default can be replaced by e.g. an object store.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrInvalidKey is returned when a key is empty or would escape the storage root.
+var ErrInvalidKey = errors.New("invalid storage key")
+
+// Storage stores uploaded files under slash-separated keys such as "products/12/abc/original.jpg".
+// Implementations must be safe for concurrent use.
+type Storage interface {
+	// Put stores the content read from r under key, replacing any existing file.
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	// Delete removes the file stored under key. Deleting a missing file is not an error.
+	Delete(ctx context.Context, key string) error
+	// URL returns the public URL of the file stored under key.
+	URL(key string) string
+}
+
+// Local is a Storage which keeps files in a directory on the local filesystem. The files are
+// expected to be served by an HTTP file server at BaseURL.
+type Local struct {
+	Dir     string
+	BaseURL string
+}
+
+// NewLocal returns a Local storage rooted at dir, creating the directory if it doesn't exist.
+func NewLocal(dir, baseURL string) (*Local, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Local{
+		Dir:     dir,
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+	}, nil
+}
+
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(name), 0o755)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first and rename it into place, so that a failed upload never
+	// leaves a half-written file behind under the final name.
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func (l *Local) URL(key string) string {
+	return l.BaseURL + "/" + key
+}
+
+// path converts a key to a filesystem path inside l.Dir.
+func (l *Local) path(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if key == "" || cleaned == "/" || cleaned != "/"+key {
+		return "", ErrInvalidKey
+	}
+
+	return filepath.Join(l.Dir, filepath.FromSlash(cleaned)), nil
+}
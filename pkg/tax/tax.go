@@ -0,0 +1,103 @@
+// Package tax calculates the sales tax of order lines from a table of rules. A rule sets the rate
+// for a destination, optionally narrowed down to a region and a product category, and says
+// whether prices already include the tax. Amounts are in minor units of the currency and rates
+// are in basis points, so 20% is 2000.
+package tax
+
+import (
+	"strings"
+)
+
+// Everywhere is the country of a rule which applies to every destination without a more specific
+// rule.
+const Everywhere = "*"
+
+// Rule is a tax rate. Country is an ISO 3166-1 alpha-2 code or Everywhere, Region an ISO 3166-2
+// code and Category a product category; an empty Region or Category matches every region or
+// category. With Inclusive set, prices already contain the tax and it is taken out of them;
+// otherwise the tax is added on top.
+type Rule struct {
+	ID        int64
+	Name      string
+	Country   string
+	Region    string
+	Category  string
+	RateBPS   int
+	Inclusive bool
+}
+
+// Line is the tax of an amount.
+type Line struct {
+	Net   int64
+	Tax   int64
+	Gross int64
+	Rule  *Rule
+}
+
+// Match returns the most specific rule for a destination and category, or nil if none of the
+// rules applies. A rule for the region beats one for the country, which beats an Everywhere rule,
+// and between those a rule for the category beats a rule for every category.
+func Match(rules []*Rule, country, region, category string) *Rule {
+	var best *Rule
+	bestScore := 0
+
+	for _, rule := range rules {
+		score := 1
+
+		switch {
+		case rule.Country == Everywhere:
+		case rule.Country == country:
+			score += 2
+		default:
+			continue
+		}
+
+		switch {
+		case rule.Region == "":
+		case rule.Region == region:
+			score += 4
+		default:
+			continue
+		}
+
+		switch {
+		case rule.Category == "":
+		case strings.EqualFold(rule.Category, category):
+			score++
+		default:
+			continue
+		}
+
+		if score > bestScore {
+			best, bestScore = rule, score
+		}
+	}
+
+	return best
+}
+
+// Calculate applies the rule to amount. A nil rule means that no tax is due.
+func Calculate(rule *Rule, amount int64) Line {
+	line := Line{Net: amount, Gross: amount, Rule: rule}
+
+	if rule == nil || rule.RateBPS == 0 {
+		return line
+	}
+
+	rate := int64(rule.RateBPS)
+
+	if rule.Inclusive {
+		line.Net = divRound(amount*10000, 10000+rate)
+		line.Tax = amount - line.Net
+		return line
+	}
+
+	line.Tax = divRound(amount*rate, 10000)
+	line.Gross = amount + line.Tax
+	return line
+}
+
+// divRound divides two non-negative numbers, rounding half up.
+func divRound(a, b int64) int64 {
+	return (a + b/2) / b
+}
@@ -0,0 +1,75 @@
+// Package thumbnail scales images down for the thumbnails generated for uploaded product images.
+// It only depends on the standard library.
+package thumbnail
+
+import (
+	"image"
+	"image/color"
+)
+
+// Resize scales src down so that neither side is longer than maxSide, keeping the aspect ratio.
+// Images which are already small enough keep their size. Each destination pixel is the average
+// of the source pixels it covers, and transparent areas are flattened onto a white background so
+// that the result can be encoded as JPEG.
+func Resize(src image.Image, maxSide int) *image.RGBA {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	dw, dh := w, h
+	if w > maxSide || h > maxSide {
+		if w >= h {
+			dw = maxSide
+			dh = h * maxSide / w
+		} else {
+			dh = maxSide
+			dw = w * maxSide / h
+		}
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		sy0 := bounds.Min.Y + y*h/dh
+		sy1 := bounds.Min.Y + (y+1)*h/dh
+		if sy1 <= sy0 {
+			sy1 = sy0 + 1
+		}
+
+		for x := 0; x < dw; x++ {
+			sx0 := bounds.Min.X + x*w/dw
+			sx1 := bounds.Min.X + (x+1)*w/dw
+			if sx1 <= sx0 {
+				sx1 = sx0 + 1
+			}
+
+			var r, g, b, n uint64
+
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					// RGBA returns alpha-premultiplied values, so adding the missing alpha
+					// composites the pixel over white.
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r += uint64(pr + 0xffff - pa)
+					g += uint64(pg + 0xffff - pa)
+					b += uint64(pb + 0xffff - pa)
+					n++
+				}
+			}
+
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: 0xffff,
+			})
+		}
+	}
+
+	return dst
+}